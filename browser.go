@@ -0,0 +1,63 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "net"
+    "os/exec"
+    "runtime"
+    "time"
+)
+
+// openBrowser opens url in the user's default browser. It is a variable so
+// that it can be replaced where no browser should be started.
+var openBrowser = func(url string) error {
+    var cmd *exec.Cmd
+    switch runtime.GOOS {
+    case "darwin":
+        cmd = exec.Command("open", url)
+    case "windows":
+        cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+    default:
+        cmd = exec.Command("xdg-open", url)
+    }
+    if err := cmd.Start(); err != nil {
+        return err
+    }
+    // Reap the launcher in the background rather than leaving a zombie.
+    go cmd.Wait()
+    return nil
+}
+
+// launchBrowser waits for the server on addr to accept connections and then
+// opens it in a browser, so that the first page load is not refused.
+func launchBrowser(addr, scheme string) {
+    host, port, err := net.SplitHostPort(addr)
+    if err != nil {
+        log.Printf("warning: not opening a browser: %v", err)
+        return
+    }
+    if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+        host = "localhost"
+    }
+    target := net.JoinHostPort(host, port)
+
+    deadline := time.Now().Add(10 * time.Second)
+    for {
+        conn, err := net.DialTimeout("tcp", target, time.Second)
+        if err == nil {
+            conn.Close()
+            break
+        }
+        if time.Now().After(deadline) {
+            log.Printf("warning: not opening a browser: %v", err)
+            return
+        }
+        time.Sleep(50 * time.Millisecond)
+    }
+
+    url := fmt.Sprintf("%s://%s/", scheme, target)
+    if err := openBrowser(url); err != nil {
+        log.Printf("warning: could not open a browser at %s: %v", url, err)
+    }
+}
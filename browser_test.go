@@ -0,0 +1,42 @@
+package main
+
+import (
+    "net"
+    "testing"
+)
+
+func TestLaunchBrowser(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    _, port, _ := net.SplitHostPort(ln.Addr().String())
+
+    var opened []string
+    saved := openBrowser
+    t.Cleanup(func() { openBrowser = saved })
+    openBrowser = func(url string) error {
+        opened = append(opened, url)
+        return nil
+    }
+
+    for _, tt := range []struct {
+        addr, scheme, want string
+    }{
+        {"127.0.0.1:" + port, "http", "http://127.0.0.1:" + port + "/"},
+        // Wildcard addresses are opened through localhost.
+        {":" + port, "https", "https://localhost:" + port + "/"},
+        {"0.0.0.0:" + port, "http", "http://localhost:" + port + "/"},
+        {"no port", "http", ""},
+    } {
+        opened = nil
+        launchBrowser(tt.addr, tt.scheme)
+        switch {
+        case tt.want == "" && len(opened) > 0:
+            t.Errorf("%s: opened %q", tt.addr, opened)
+        case tt.want != "" && (len(opened) != 1 || opened[0] != tt.want):
+            t.Errorf("%s: opened %q, want %s", tt.addr, opened, tt.want)
+        }
+    }
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "container/list"
+    "io"
+    "os"
+    "sync"
+    "time"
+)
+
+// assetCache keeps the contents of recently served files in memory, up to
+// maxBytes in total, evicting the least recently used first. Entries are
+// dropped as soon as the file's modification time or size changes.
+type assetCache struct {
+    maxBytes    int64
+    maxFileSize int64
+
+    mu      sync.Mutex
+    size    int64
+    order   *list.List
+    entries map[string]*list.Element
+}
+
+type cachedAsset struct {
+    name    string
+    modTime time.Time
+    data    []byte
+}
+
+func newAssetCache(maxBytes, maxFileSize int64) *assetCache {
+    return &assetCache{
+        maxBytes:    maxBytes,
+        maxFileSize: min(maxFileSize, maxBytes),
+        order:       list.New(),
+        entries:     map[string]*list.Element{},
+    }
+}
+
+// get returns the contents of the open file f, reading it into the cache
+// on a miss and leaving its offset at the start. It returns nil for files
+// too large to cache.
+func (c *assetCache) get(name string, f *os.File, info os.FileInfo) ([]byte, error) {
+    if info.Size() > c.maxFileSize {
+        return nil, nil
+    }
+    c.mu.Lock()
+    if el, ok := c.entries[name]; ok {
+        a := el.Value.(*cachedAsset)
+        if a.modTime.Equal(info.ModTime()) && int64(len(a.data)) == info.Size() {
+            c.order.MoveToFront(el)
+            c.mu.Unlock()
+            return a.data, nil
+        }
+        c.remove(el)
+    }
+    c.mu.Unlock()
+
+    data := make([]byte, info.Size())
+    if _, err := io.ReadFull(f, data); err != nil {
+        return nil, err
+    }
+    if _, err := f.Seek(0, io.SeekStart); err != nil {
+        return nil, err
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if el, ok := c.entries[name]; ok {
+        c.remove(el)
+    }
+    c.entries[name] = c.order.PushFront(&cachedAsset{
+        name:    name,
+        modTime: info.ModTime(),
+        data:    data,
+    })
+    c.size += int64(len(data))
+    for c.size > c.maxBytes {
+        c.remove(c.order.Back())
+    }
+    return data, nil
+}
+
+// remove drops an entry. c.mu must be held.
+func (c *assetCache) remove(el *list.Element) {
+    a := c.order.Remove(el).(*cachedAsset)
+    delete(c.entries, a.name)
+    c.size -= int64(len(a.data))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestAssetCacheInvalidation(t *testing.T) {
+    root := writeTree(t, map[string]string{"app.js": "one"})
+    name := filepath.Join(root, "app.js")
+    h := newTestFileHandler(t, root)
+    h.cache = newAssetCache(1<<20, 1<<10)
+
+    rewrite := func(content string, mtime time.Time) {
+        t.Helper()
+        if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.Chtimes(name, mtime, mtime); err != nil {
+            t.Fatal(err)
+        }
+    }
+    mtime := time.Now().Add(-time.Hour)
+    rewrite("one", mtime)
+    if body := get(h, "/app.js").Body.String(); body != "one" {
+        t.Fatalf("first request: %q", body)
+    }
+    for _, tt := range []struct {
+        name, content string
+        mtime         time.Time
+    }{
+        // Same size, so only the new modification time gives it away.
+        {"modtime", "two", mtime.Add(time.Minute)},
+        // Same modification time, so only the new size gives it away.
+        {"size", "three", mtime.Add(time.Minute)},
+    } {
+        rewrite(tt.content, tt.mtime)
+        if body := get(h, "/app.js").Body.String(); body != tt.content {
+            t.Errorf("after changing %s: %q, want %q", tt.name, body, tt.content)
+        }
+    }
+    if n := len(h.cache.entries); n != 1 {
+        t.Errorf("%d cache entries, want 1", n)
+    }
+}
+
+func TestAssetCacheEviction(t *testing.T) {
+    root := writeTree(t, map[string]string{
+        "a.js":   "aaaa",
+        "b.js":   "bbbb",
+        "c.js":   "cccc",
+        "big.js": "0123456789",
+    })
+    h := newTestFileHandler(t, root)
+    h.cache = newAssetCache(8, 8)
+    for _, target := range []string{"/a.js", "/b.js", "/a.js", "/c.js", "/big.js"} {
+        get(h, target)
+    }
+    // b.js was the least recently used, and big.js is over the file limit.
+    for name, want := range map[string]bool{"a.js": true, "b.js": false, "c.js": true, "big.js": false} {
+        if _, ok := h.cache.entries[filepath.Join(root, name)]; ok != want {
+            t.Errorf("%s cached: %v, want %v", name, ok, want)
+        }
+    }
+    if h.cache.size != 8 {
+        t.Errorf("cache holds %d bytes, want 8", h.cache.size)
+    }
+}
+
+func BenchmarkServeCached(b *testing.B) {
+    dir := b.TempDir()
+    data := make([]byte, 64<<10)
+    if err := os.WriteFile(filepath.Join(dir, "app.wasm"), data, 0o644); err != nil {
+        b.Fatal(err)
+    }
+    for _, bb := range []struct {
+        name  string
+        cache *assetCache
+    }{
+        {"disk", nil},
+        {"cached", newAssetCache(1<<20, 1<<20)},
+    } {
+        b.Run(bb.name, func(b *testing.B) {
+            h, err := newFileHandler(dir)
+            if err != nil {
+                b.Fatal(err)
+            }
+            h.cache = bb.cache
+            req := httptest.NewRequest("GET", "/app.wasm", nil)
+            b.SetBytes(int64(len(data)))
+            b.ReportAllocs()
+            for b.Loop() {
+                h.ServeHTTP(httptest.NewRecorder(), req)
+            }
+        })
+    }
+}
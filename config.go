@@ -0,0 +1,421 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    "golang.org/x/text/language"
+)
+
+// Config holds every setting of the server. It is filled from defaults,
+// then the -config file, then the command line, each overriding the last.
+type Config struct {
+    Addr             string     `json:"addr"`
+    SocketMode       string     `json:"socketMode"`
+    Roots            stringList `json:"roots"`
+    COI              bool       `json:"coi"`
+    SPA              bool       `json:"spa"`
+    Immutable        string     `json:"immutable"`
+    GzipMinSize      int        `json:"gzipMinSize"`
+    Listing          bool       `json:"listing"`
+    Preload          stringList `json:"preload"`
+    DefaultLang      string     `json:"defaultLang"`
+    SRI              bool       `json:"sri"`
+    CacheSize        int64      `json:"cacheSize"`
+    CacheMaxFileSize int64      `json:"cacheMaxFileSize"`
+    Dev              bool       `json:"dev"`
+    Open             bool       `json:"open"`
+    DryRun           bool       `json:"-"`
+    H2C              bool       `json:"h2c"`
+    Metrics          bool       `json:"metrics"`
+    MaxConns         int        `json:"maxConns"`
+    ShutdownTimeout  duration   `json:"shutdownTimeout"`
+    TrustProxy       bool       `json:"trustProxy"`
+    AllowCIDRs       stringList `json:"allowCIDRs"`
+    DenyCIDRs        stringList `json:"denyCIDRs"`
+    Headers          stringList `json:"headers"`
+
+    TLS struct {
+        Cert           string `json:"cert"`
+        Key            string `json:"key"`
+        AutocertDomain string `json:"autocertDomain"`
+        AutocertCache  string `json:"autocertCache"`
+        RedirectAddr   string `json:"redirectAddr"`
+    } `json:"tls"`
+
+    Log struct {
+        Format     string `json:"format"`
+        File       string `json:"file"`
+        MaxSize    int    `json:"maxSize"`
+        MaxBackups int    `json:"maxBackups"`
+        MaxAge     int    `json:"maxAge"`
+        Stderr     bool   `json:"stderr"`
+    } `json:"log"`
+
+    Relay struct {
+        MaxPlayers     int      `json:"maxPlayers"`
+        RoomTTL        duration `json:"roomTTL"`
+        ReconnectGrace duration `json:"reconnectGrace"`
+        WebTransport   bool     `json:"webTransport"`
+        Seq            bool     `json:"seq"`
+        LogFrames      bool     `json:"logFrames"`
+    } `json:"relay"`
+
+    CORS struct {
+        Origins     stringList `json:"origins"`
+        Credentials bool       `json:"credentials"`
+    } `json:"cors"`
+
+    Auth struct {
+        User string `json:"user"`
+        Pass string `json:"pass"`
+        Hash string `json:"hash"`
+    } `json:"auth"`
+
+    CSP struct {
+        Policy     string `json:"policy"`
+        ReportOnly bool   `json:"reportOnly"`
+    } `json:"csp"`
+
+    Timeouts struct {
+        ReadHeader duration `json:"readHeader"`
+        Read       duration `json:"read"`
+        Write      duration `json:"write"`
+        Idle       duration `json:"idle"`
+    } `json:"timeouts"`
+
+    RateLimit struct {
+        Requests    float64 `json:"requests"`
+        Burst       int     `json:"burst"`
+        Sockets     float64 `json:"sockets"`
+        SocketBurst int     `json:"socketBurst"`
+    } `json:"rateLimit"`
+}
+
+// duration is a time.Duration written as a string such as "30s" in the
+// config file.
+type duration struct {
+    time.Duration
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+    var s string
+    if err := json.Unmarshal(b, &s); err != nil {
+        return fmt.Errorf("duration %s must be a string like \"30s\"", b)
+    }
+    v, err := time.ParseDuration(s)
+    if err != nil {
+        return err
+    }
+    d.Duration = v
+    return nil
+}
+
+// registerFlags defines a flag for each setting, storing its default in c.
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+    fs.StringVar(&c.Addr, "addr", "",
+        "address to listen on, or unix:/path for a UNIX socket "+
+            "(default :8083 or $PORT)")
+    fs.StringVar(&c.SocketMode, "socket-mode", "0660",
+        "octal permissions for a UNIX socket -addr")
+    fs.Var(&c.Roots, "root", "directory to serve files from, searched in the "+
+        "order given (repeatable, default zig-out/htmlout or $THIERD_ROOT)")
+    fs.BoolVar(&c.COI, "coi", true,
+        "send cross-origin isolation headers (COOP/COEP)")
+    fs.BoolVar(&c.SPA, "spa", false,
+        "serve index.html for missing paths without a file extension")
+    fs.StringVar(&c.Immutable, "immutable", `\.[0-9a-f]{8}\.`,
+        "regexp matching fingerprinted file names to cache forever")
+    fs.IntVar(&c.GzipMinSize, "gzip-min-size", 1024,
+        "smallest response in bytes to gzip, negative to disable")
+    fs.BoolVar(&c.Listing, "listing", false,
+        "list the contents of directories without an index.html")
+    fs.Var(&c.Preload, "preload", "URL path to preload from index.html, "+
+        "e.g. /app.wasm (repeatable, default the single .wasm next to the "+
+        "index and its .js loader, empty to disable)")
+    fs.BoolVar(&c.SRI, "sri", false,
+        "add Subresource Integrity hashes to the scripts index.html loads")
+    fs.StringVar(&c.DefaultLang, "default-lang", "en",
+        "language of the index.<lang>.html served when none match Accept-Language")
+    fs.Int64Var(&c.CacheSize, "cache-size", 0,
+        "bytes of file contents to keep in memory, 0 to disable")
+    fs.Int64Var(&c.CacheMaxFileSize, "cache-max-file-size", 16<<20,
+        "largest file in bytes to keep in the memory cache")
+    fs.BoolVar(&c.Dev, "dev", false,
+        "reload open pages when files under the root change")
+    fs.BoolVar(&c.DryRun, "dry-run", false,
+        "check the settings and the files to serve, then exit")
+    fs.BoolVar(&c.Open, "open", false,
+        "open the server in the default browser once it is listening")
+    fs.BoolVar(&c.H2C, "h2c", false,
+        "accept HTTP/2 without TLS (prior knowledge or h2c upgrade)")
+    fs.BoolVar(&c.Metrics, "metrics", false,
+        "expose Prometheus metrics on /metrics")
+    fs.IntVar(&c.MaxConns, "max-conns", 0,
+        "maximum simultaneous connections per listener, 0 for no limit")
+    fs.DurationVar(&c.ShutdownTimeout.Duration, "shutdown-timeout", 10*time.Second,
+        "how long to wait for in-flight requests on shutdown")
+    fs.BoolVar(&c.TrustProxy, "trust-proxy", false,
+        "take client IPs from X-Forwarded-For")
+    fs.Var(&c.AllowCIDRs, "allow-cidr",
+        "only accept clients in this network, e.g. 10.0.0.0/8 (repeatable)")
+    fs.Var(&c.DenyCIDRs, "deny-cidr",
+        "refuse clients in this network (repeatable)")
+    fs.Var(&c.Headers, "header",
+        `extra "Name: Value" header for static responses (repeatable)`)
+
+    fs.StringVar(&c.TLS.Cert, "tls-cert", "", "TLS certificate file")
+    fs.StringVar(&c.TLS.Key, "tls-key", "", "TLS private key file")
+    fs.StringVar(&c.TLS.AutocertDomain, "autocert-domain", "",
+        "comma separated domains to obtain Let's Encrypt certificates for")
+    fs.StringVar(&c.TLS.AutocertCache, "autocert-cache", "autocert-cache",
+        "directory to cache Let's Encrypt certificates in")
+    fs.StringVar(&c.TLS.RedirectAddr, "redirect-addr", "",
+        "address for a plain HTTP listener redirecting to HTTPS, e.g. :80")
+
+    fs.StringVar(&c.Log.Format, "log-format", "",
+        "access log format: text, json or none (default text, or json "+
+            "with -log-file)")
+    fs.StringVar(&c.Log.File, "log-file", "",
+        "write the access log to this file, rotating it by size")
+    fs.IntVar(&c.Log.MaxSize, "log-max-size", 100,
+        "megabytes the -log-file may grow to before it is rotated")
+    fs.IntVar(&c.Log.MaxBackups, "log-max-backups", 5,
+        "rotated log files to keep, 0 to keep all")
+    fs.IntVar(&c.Log.MaxAge, "log-max-age", 28,
+        "days to keep rotated log files, 0 to keep them regardless of age")
+    fs.BoolVar(&c.Log.Stderr, "log-stderr", false,
+        "also write the access log to stderr when -log-file is set")
+
+    fs.IntVar(&c.Relay.MaxPlayers, "max-players", 8,
+        "maximum number of clients in a relay room")
+    fs.DurationVar(&c.Relay.RoomTTL.Duration, "room-ttl", 5*time.Minute,
+        "how long a created room may stay empty before it is removed")
+    fs.DurationVar(&c.Relay.ReconnectGrace.Duration, "reconnect-grace", 30*time.Second,
+        "how long a disconnected relay player's slot is held for it")
+    fs.BoolVar(&c.Relay.WebTransport, "webtransport", false,
+        "also serve the relay over HTTP/3 WebTransport at /wt (requires TLS)")
+    fs.BoolVar(&c.Relay.Seq, "seq", false,
+        "prefix relayed messages with a little endian uint64 sequence number")
+    fs.BoolVar(&c.Relay.LogFrames, "log-frames", false,
+        "log every message relay clients send, not only a summary per connection")
+
+    fs.Var(&c.CORS.Origins, "cors-origin",
+        "origin allowed to make cross-origin requests, or * (repeatable)")
+    fs.BoolVar(&c.CORS.Credentials, "cors-credentials", false,
+        "allow credentialed cross-origin requests")
+
+    fs.StringVar(&c.Auth.User, "auth-user", "",
+        "require HTTP Basic auth with this user name")
+    fs.StringVar(&c.Auth.Pass, "auth-pass", "", "password for -auth-user")
+    fs.StringVar(&c.Auth.Hash, "auth-hash", "",
+        "bcrypt hash of the password for -auth-user")
+
+    // Compiling WebAssembly counts as eval, so script-src needs
+    // 'wasm-unsafe-eval' or browsers will refuse to instantiate the build.
+    // Older browsers without it need 'unsafe-eval' instead.
+    fs.StringVar(&c.CSP.Policy, "csp",
+        "default-src 'self'; script-src 'self' 'wasm-unsafe-eval'",
+        "Content-Security-Policy for HTML responses, empty to disable")
+    fs.BoolVar(&c.CSP.ReportOnly, "csp-report-only", false,
+        "send the policy as Content-Security-Policy-Report-Only")
+
+    fs.DurationVar(&c.Timeouts.ReadHeader.Duration, "read-header-timeout", 5*time.Second,
+        "maximum time to read request headers")
+    fs.DurationVar(&c.Timeouts.Read.Duration, "read-timeout", 30*time.Second,
+        "maximum time to read a whole request, 0 for none")
+    // Large WASM downloads over slow links can take minutes, so writes are
+    // unbounded by default. WebSocket handlers clear both deadlines once the
+    // connection is upgraded, as the server's would otherwise cut them off.
+    fs.DurationVar(&c.Timeouts.Write.Duration, "write-timeout", 0,
+        "maximum time to write a response, 0 for none")
+    fs.DurationVar(&c.Timeouts.Idle.Duration, "idle-timeout", 120*time.Second,
+        "how long to keep idle keep-alive connections open")
+
+    fs.Float64Var(&c.RateLimit.Requests, "rate-limit", 0,
+        "HTTP requests per second allowed per client IP, 0 for no limit")
+    fs.IntVar(&c.RateLimit.Burst, "rate-burst", 50,
+        "HTTP requests a client IP may make in a burst")
+    fs.Float64Var(&c.RateLimit.Sockets, "ws-rate-limit", 0,
+        "WebSocket connections per second allowed per client IP, 0 for no limit")
+    fs.IntVar(&c.RateLimit.SocketBurst, "ws-rate-burst", 5,
+        "WebSocket connections a client IP may open in a burst")
+}
+
+// parseConfig parses the command line into c, layering it over the file
+// named by -config when there is one.
+func (c *Config) parseConfig(fs *flag.FlagSet, args []string) error {
+    configFile := fs.String("config", "",
+        "JSON file of settings, overridden by any flags given")
+    c.registerFlags(fs)
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    if *configFile == "" {
+        return nil
+    }
+    if err := c.load(*configFile); err != nil {
+        return err
+    }
+    // The file has overwritten any flags as well as the defaults, so parse
+    // the flags again on top of it. A repeated flag replaces the file's
+    // list rather than extending it.
+    fs.Visit(func(f *flag.Flag) {
+        if l, ok := f.Value.(*stringList); ok {
+            *l = nil
+        }
+    })
+    return fs.Parse(args)
+}
+
+// load overlays the settings in a JSON file onto c. Settings missing from
+// the file keep their current values.
+func (c *Config) load(name string) error {
+    data, err := os.ReadFile(name)
+    if err != nil {
+        return err
+    }
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(c); err != nil {
+        return fmt.Errorf("%s: %w", name, err)
+    }
+    return nil
+}
+
+// resolve fills in the settings whose defaults depend on the environment or
+// on other settings. It reports whether the roots were chosen explicitly,
+// rather than left to default to the build output.
+func (c *Config) resolve() (explicitRoots bool) {
+    if c.Addr == "" {
+        c.Addr = ":8083"
+        if port := os.Getenv("PORT"); port != "" {
+            c.Addr = ":" + port
+        }
+    }
+    if len(c.Roots) == 0 {
+        if dirs := os.Getenv("THIERD_ROOT"); dirs != "" {
+            c.Roots = filepath.SplitList(dirs)
+        }
+    }
+    explicitRoots = len(c.Roots) > 0
+    if !explicitRoots {
+        c.Roots = stringList{"zig-out/htmlout"}
+    }
+    if c.Log.Format == "" {
+        // Files are meant for log shippers, which want JSON.
+        c.Log.Format = "text"
+        if c.Log.File != "" {
+            c.Log.Format = "json"
+        }
+    }
+    return explicitRoots
+}
+
+// validate checks the merged settings, reporting every problem found by the
+// name of the setting in the config file.
+func (c *Config) validate() error {
+    var errs []error
+    fail := func(format string, args ...any) {
+        errs = append(errs, fmt.Errorf(format, args...))
+    }
+
+    hasTLS := c.TLS.Cert != "" || c.TLS.AutocertDomain != ""
+    switch {
+    case c.TLS.Cert != "" && c.TLS.Key == "":
+        fail("tls.cert set but tls.key missing")
+    case c.TLS.Key != "" && c.TLS.Cert == "":
+        fail("tls.key set but tls.cert missing")
+    }
+    if c.TLS.Cert != "" && c.TLS.AutocertDomain != "" {
+        fail("tls.autocertDomain cannot be combined with tls.cert")
+    }
+    if c.TLS.RedirectAddr != "" && !hasTLS {
+        fail("tls.redirectAddr requires tls.cert or tls.autocertDomain")
+    }
+    if c.Relay.WebTransport && !hasTLS {
+        fail("relay.webTransport requires tls.cert or tls.autocertDomain")
+    }
+    if strings.HasPrefix(c.Addr, "unix:") {
+        if c.Relay.WebTransport {
+            fail("relay.webTransport needs a UDP port, not a UNIX socket addr")
+        }
+        if c.Open {
+            fail("open cannot be used with a UNIX socket addr")
+        }
+    }
+    if mode, err := strconv.ParseUint(c.SocketMode, 8, 32); err != nil || mode > 0o777 {
+        fail("socketMode: %q is not an octal file mode", c.SocketMode)
+    }
+    if c.H2C && hasTLS {
+        fail("h2c cannot be combined with TLS, which already negotiates HTTP/2")
+    }
+    if c.Relay.MaxPlayers < 1 {
+        fail("relay.maxPlayers must be at least 1")
+    }
+
+    if (c.Auth.Pass != "" || c.Auth.Hash != "") && c.Auth.User == "" {
+        fail("auth.pass and auth.hash require auth.user")
+    }
+    if c.Auth.User != "" && (c.Auth.Pass == "") == (c.Auth.Hash == "") {
+        fail("auth.user requires exactly one of auth.pass or auth.hash")
+    }
+
+    switch c.Log.Format {
+    case "text", "json", "none":
+    default:
+        fail("log.format must be text, json or none, not %q", c.Log.Format)
+    }
+    if err := checkCSP(c.CSP.Policy); err != nil {
+        fail("csp.policy: %v", err)
+    }
+    if c.Immutable != "" {
+        if _, err := regexp.Compile(c.Immutable); err != nil {
+            fail("immutable: %v", err)
+        }
+    }
+    if _, err := language.Parse(c.DefaultLang); err != nil {
+        fail("defaultLang: %v", err)
+    }
+    for _, p := range c.Preload {
+        if p != "" && !strings.HasPrefix(p, "/") {
+            fail("preload: %q must be an absolute URL path", p)
+        }
+    }
+    if _, err := parseCIDRs(c.AllowCIDRs); err != nil {
+        fail("allowCIDRs: %v", err)
+    }
+    if _, err := parseCIDRs(c.DenyCIDRs); err != nil {
+        fail("denyCIDRs: %v", err)
+    }
+    if _, err := parseHeaders(c.Headers); err != nil {
+        fail("headers: %v", err)
+    }
+    return errors.Join(errs...)
+}
+
+// checkCSP catches policies that browsers would not parse as intended, such
+// as source lists left without a directive name.
+func checkCSP(policy string) error {
+    for _, directive := range strings.Split(policy, ";") {
+        fields := strings.Fields(directive)
+        if len(fields) == 0 {
+            continue
+        }
+        for _, c := range fields[0] {
+            if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '-') {
+                return fmt.Errorf("bad directive name %q", fields[0])
+            }
+        }
+    }
+    return nil
+}
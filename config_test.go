@@ -0,0 +1,95 @@
+package main
+
+import (
+    "flag"
+    "io"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "testing"
+    "time"
+)
+
+// parseArgs parses args into a fresh Config as the command line would be.
+func parseArgs(t *testing.T, args ...string) (*Config, error) {
+    t.Helper()
+    t.Setenv("PORT", "")
+    t.Setenv("THIERD_ROOT", "")
+    var cfg Config
+    fs := flag.NewFlagSet("thierd", flag.ContinueOnError)
+    fs.SetOutput(io.Discard)
+    if err := cfg.parseConfig(fs, args); err != nil {
+        return nil, err
+    }
+    cfg.resolve()
+    return &cfg, cfg.validate()
+}
+
+func TestConfigPrecedence(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "thierd.json")
+    if err := os.WriteFile(file, []byte(`{
+        "addr": ":9000",
+        "roots": ["from-file"],
+        "spa": true,
+        "relay": {"maxPlayers": 4}
+    }`), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    cfg, err := parseArgs(t, "-config", file, "-addr", ":7000", "-root", "from-flag")
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, tt := range []struct {
+        setting   string
+        got, want any
+    }{
+        {"addr from the flag", cfg.Addr, ":7000"},
+        {"spa from the file", cfg.SPA, true},
+        {"maxPlayers from the file", cfg.Relay.MaxPlayers, 4},
+        {"roomTTL by default", cfg.Relay.RoomTTL.Duration, 5 * time.Minute},
+        {"coi by default", cfg.COI, true},
+    } {
+        if tt.got != tt.want {
+            t.Errorf("%s: %v, want %v", tt.setting, tt.got, tt.want)
+        }
+    }
+    // A repeated flag replaces the file's list rather than extending it.
+    if !slices.Equal(cfg.Roots, stringList{"from-flag"}) {
+        t.Errorf("roots %q, want [from-flag]", cfg.Roots)
+    }
+
+    cfg, err = parseArgs(t)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cfg.Addr != ":8083" || !slices.Equal(cfg.Roots, stringList{"zig-out/htmlout"}) {
+        t.Errorf("defaults: addr %q, roots %q", cfg.Addr, cfg.Roots)
+    }
+}
+
+func TestConfigValidation(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "thierd.json")
+    if err := os.WriteFile(file, []byte(`{"logFormat": "json"}`), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := parseArgs(t, "-config", file); err == nil ||
+            !strings.Contains(err.Error(), `unknown field "logFormat"`) {
+        t.Errorf("unknown field: %v", err)
+    }
+
+    _, err := parseArgs(t, "-tls-key", "key.pem", "-max-players", "0", "-log-format", "xml")
+    if err == nil {
+        t.Fatal("invalid settings were accepted")
+    }
+    // Every problem is reported, not just the first.
+    for _, want := range []string{
+        "tls.key set but tls.cert missing",
+        "relay.maxPlayers must be at least 1",
+        `log.format must be text, json or none, not "xml"`,
+    } {
+        if !strings.Contains(err.Error(), want) {
+            t.Errorf("error %q lacks %q", err, want)
+        }
+    }
+}
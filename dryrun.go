@@ -0,0 +1,79 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "path"
+    "strings"
+)
+
+// dryRun checks that the server could start with cfg and that the build it
+// would serve looks complete, writing a summary to out. It returns every
+// problem found.
+func dryRun(cfg *Config, explicitRoots bool, out io.Writer) error {
+    var errs []error
+    var warnings []string
+
+    scheme := "http"
+    if _, _, err := tlsConfig(cfg.TLS.Cert, cfg.TLS.Key,
+            cfg.TLS.AutocertDomain, cfg.TLS.AutocertCache); err != nil {
+        errs = append(errs, fmt.Errorf("tls: %w", err))
+    } else if cfg.TLS.Cert != "" || cfg.TLS.AutocertDomain != "" {
+        scheme = "https"
+    }
+    fmt.Fprintf(out, "listen:  %s (%s)\n", cfg.Addr, scheme)
+
+    var trees []fs.FS
+    fsys, err := embeddedFiles()
+    if err != nil {
+        warnings = append(warnings, fmt.Sprintf("not using embedded files: %v", err))
+    }
+    if fsys != nil && !explicitRoots {
+        trees = append(trees, fsys)
+        fmt.Fprintln(out, "files:   embedded")
+    } else if files, err := newFileHandler(cfg.Roots...); err != nil {
+        errs = append(errs, err)
+    } else {
+        for _, root := range files.roots {
+            trees = append(trees, os.DirFS(root))
+        }
+        fmt.Fprintf(out, "files:   %s\n", strings.Join(files.roots, ", "))
+    }
+
+    if len(trees) > 0 {
+        index := false
+        var modules []string
+        for _, tree := range trees {
+            if _, err := fs.Stat(tree, "index.html"); err == nil {
+                index = true
+            }
+            fs.WalkDir(tree, ".", func(name string, d fs.DirEntry, err error) error {
+                if err == nil && !d.IsDir() && path.Ext(name) == ".wasm" {
+                    modules = append(modules, "/"+name)
+                }
+                return nil
+            })
+        }
+        if !index {
+            errs = append(errs, errors.New("no index.html in the root; "+
+                "run `zig build` first"))
+        }
+        if len(modules) == 0 {
+            warnings = append(warnings, "no .wasm module in the root")
+        } else {
+            fmt.Fprintf(out, "wasm:    %s\n", strings.Join(modules, ", "))
+        }
+    }
+
+    for _, w := range warnings {
+        fmt.Fprintf(out, "warning: %s\n", w)
+    }
+    if err := errors.Join(errs...); err != nil {
+        return err
+    }
+    fmt.Fprintln(out, "ok")
+    return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+    "bytes"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestDryRun(t *testing.T) {
+    good := writeTree(t, map[string]string{
+        "index.html": "index",
+        "app.wasm":   "\x00asm",
+    })
+    var cfg Config
+    cfg.Addr = ":8083"
+    cfg.Roots = stringList{good}
+    var out bytes.Buffer
+    if err := dryRun(&cfg, true, &out); err != nil {
+        t.Fatalf("good config: %v\n%s", err, out.String())
+    }
+    for _, want := range []string{
+        "listen:  :8083 (http)\n", "files:   " + good + "\n", "wasm:    /app.wasm\n", "ok\n",
+    } {
+        if !strings.Contains(out.String(), want) {
+            t.Errorf("good config: summary %q lacks %q", out.String(), want)
+        }
+    }
+
+    // Every problem is reported at once.
+    cfg.Roots = stringList{writeTree(t, map[string]string{"readme.txt": "hi"})}
+    cfg.TLS.Cert = filepath.Join(good, "missing.pem")
+    cfg.TLS.Key = filepath.Join(good, "missing.key")
+    out.Reset()
+    err := dryRun(&cfg, true, &out)
+    if err == nil {
+        t.Fatalf("bad config passed:\n%s", out.String())
+    }
+    for _, want := range []string{"tls:", "no index.html"} {
+        if !strings.Contains(err.Error(), want) {
+            t.Errorf("bad config: error %q lacks %q", err, want)
+        }
+    }
+    if summary := out.String(); !strings.Contains(summary, "warning: no .wasm module") ||
+            strings.Contains(summary, "ok\n") {
+        t.Errorf("bad config: summary %q", summary)
+    }
+}
@@ -0,0 +1,30 @@
+//go:build embed
+
+package main
+
+import (
+    "embed"
+    "errors"
+    "io/fs"
+)
+
+// Building with -tags embed bakes the Zig build output into the binary, so
+// zig-out/htmlout must exist at build time.
+//
+//go:embed all:zig-out/htmlout
+var embedded embed.FS
+
+func embeddedFiles() (fs.FS, error) {
+    fsys, err := fs.Sub(embedded, "zig-out/htmlout")
+    if err != nil {
+        return nil, err
+    }
+    entries, err := fs.ReadDir(fsys, ".")
+    if err != nil {
+        return nil, err
+    }
+    if len(entries) == 0 {
+        return nil, errors.New("embedded output is empty")
+    }
+    return fsys, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+    "embed"
+    "io/fs"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+//go:embed testdata/htmlout
+var testEmbedded embed.FS
+
+func TestEmbeddedFiles(t *testing.T) {
+    fsys, err := fs.Sub(testEmbedded, "testdata/htmlout")
+    if err != nil {
+        t.Fatal(err)
+    }
+    // main serves embedded files the same way.
+    h := http.FileServer(http.FS(fsys))
+
+    if rec := get(h, "/"); rec.Code != http.StatusOK ||
+            !strings.Contains(rec.Body.String(), "<title>thierd</title>") {
+        t.Errorf("GET /: %d %q", rec.Code, rec.Body)
+    }
+    if rec := get(h, "/app.js"); rec.Code != http.StatusOK ||
+            rec.Body.String() != "console.log(\"thierd\")\n" {
+        t.Errorf("GET /app.js: %d %q", rec.Code, rec.Body)
+    }
+
+    for _, target := range []string{
+        "/../embed_test.go",
+        "/..%2fembed_test.go",
+        "/%2e%2e/htmlout/index.html",
+        "/../../static.go",
+    } {
+        // FileServer may redirect to the cleaned path, which stays inside
+        // the embedded tree.
+        if rec := get(h, target); rec.Code == http.StatusOK ||
+                strings.Contains(rec.Body.String(), "package main") {
+            t.Errorf("GET %s: %d %q", target, rec.Code, rec.Body)
+        }
+    }
+    req := httptest.NewRequest("GET", "/", nil)
+    req.URL.Path = `/..\embed_test.go`
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    if rec.Code != http.StatusNotFound {
+        t.Errorf("GET %s: %d", req.URL.Path, rec.Code)
+    }
+}
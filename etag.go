@@ -0,0 +1,84 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "io"
+    "net/http"
+    "os"
+    "path"
+    "regexp"
+    "sync"
+    "time"
+)
+
+// etagCache remembers content hashes so that files are only rehashed after
+// their modification time or size changes.
+type etagCache struct {
+    mu      sync.Mutex
+    entries map[string]etagEntry
+}
+
+type etagEntry struct {
+    modTime time.Time
+    size    int64
+    tag     string
+}
+
+func newETagCache() *etagCache {
+    return &etagCache{entries: map[string]etagEntry{}}
+}
+
+// get returns a strong ETag for the open file f, hashing it on a cache
+// miss and leaving its offset at the start.
+func (c *etagCache) get(name string, f *os.File, info os.FileInfo) (string, error) {
+    c.mu.Lock()
+    e, ok := c.entries[name]
+    c.mu.Unlock()
+    if ok && e.modTime.Equal(info.ModTime()) && e.size == info.Size() {
+        return e.tag, nil
+    }
+
+    hash := sha256.New()
+    if _, err := io.Copy(hash, f); err != nil {
+        return "", err
+    }
+    if _, err := f.Seek(0, io.SeekStart); err != nil {
+        return "", err
+    }
+    tag := `"` + hex.EncodeToString(hash.Sum(nil)[:8]) + `"`
+
+    c.mu.Lock()
+    c.entries[name] = etagEntry{
+        modTime: info.ModTime(),
+        size:    info.Size(),
+        tag:     tag,
+    }
+    c.mu.Unlock()
+    return tag, nil
+}
+
+// lastModified returns the modification time to advertise for a file.
+// HTTP dates have one second resolution, so the time is truncated to whole
+// seconds; otherwise a client echoing Last-Modified back in
+// If-Modified-Since would look a fraction of a second out of date on
+// filesystems with finer timestamps. It is zero when the time is unknown.
+func lastModified(info os.FileInfo) time.Time {
+    t := info.ModTime().Truncate(time.Second)
+    if t.IsZero() || t.Equal(time.Unix(0, 0)) {
+        return time.Time{}
+    }
+    return t
+}
+
+// setCacheControl marks fingerprinted assets as immutable and makes
+// browsers revalidate index.html so that new builds are picked up.
+func setCacheControl(h http.Header, urlPath string, immutable *regexp.Regexp) {
+    base := path.Base(urlPath)
+    switch {
+    case isIndex(base):
+        h.Set("Cache-Control", "no-cache")
+    case immutable != nil && immutable.MatchString(base):
+        h.Set("Cache-Control", "public, max-age=31536000, immutable")
+    }
+}
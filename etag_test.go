@@ -0,0 +1,99 @@
+package main
+
+import (
+    "net/http"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestETag(t *testing.T) {
+    if err := registerMimeTypes(); err != nil {
+        t.Fatal(err)
+    }
+    root := writeTree(t, map[string]string{
+        "app.wasm": strings.Repeat("\x00asm", 1000),
+    })
+    h := gzipHandler(newTestFileHandler(t, root), 100)
+
+    rec := get(h, "/app.wasm")
+    tag := rec.Header().Get("ETag")
+    if rec.Code != http.StatusOK || !strings.HasPrefix(tag, `"`) {
+        t.Fatalf("identity: %d with ETag %q, want 200 and a strong tag", rec.Code, tag)
+    }
+    if rec := get(h, "/app.wasm", "If-None-Match", tag); rec.Code != http.StatusNotModified {
+        t.Errorf("identity revalidation: %d, want 304", rec.Code)
+    }
+
+    rec = get(h, "/app.wasm", "Accept-Encoding", "gzip")
+    if rec.Header().Get("Content-Encoding") != "gzip" {
+        t.Fatal("response was not compressed")
+    }
+    if got := rec.Header().Get("ETag"); got != "W/"+tag {
+        t.Errorf("gzip ETag %q, want W/%s", got, tag)
+    }
+    rec = get(h, "/app.wasm", "Accept-Encoding", "gzip", "If-None-Match", "W/"+tag)
+    if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+        t.Errorf("gzip revalidation: %d with %d bytes, want 304", rec.Code, rec.Body.Len())
+    }
+    if got := rec.Header().Get("ETag"); got != "W/"+tag {
+        t.Errorf("304 ETag %q, want W/%s", got, tag)
+    }
+}
+
+func TestCacheControl(t *testing.T) {
+    immutable := regexp.MustCompile(`\.[0-9a-f]{8}\.`)
+    for _, tt := range []struct {
+        path, want string
+    }{
+        {"/index.html", "no-cache"},
+        {"/app.3f9a1c2b.wasm", "public, max-age=31536000, immutable"},
+        {"/app.wasm", ""},
+    } {
+        h := http.Header{}
+        setCacheControl(h, tt.path, immutable)
+        if got := h.Get("Cache-Control"); got != tt.want {
+            t.Errorf("%s: Cache-Control %q, want %q", tt.path, got, tt.want)
+        }
+    }
+}
+
+func TestIfModifiedSince(t *testing.T) {
+    root := writeTree(t, map[string]string{"app.js": "console.log(1)"})
+    // A sub-second timestamp that Last-Modified cannot represent exactly.
+    mtime := time.Date(2024, 5, 1, 12, 0, 0, 500_000_000, time.UTC)
+    if err := os.Chtimes(filepath.Join(root, "app.js"), mtime, mtime); err != nil {
+        t.Fatal(err)
+    }
+    h := newTestFileHandler(t, root)
+    lastMod := get(h, "/app.js").Header().Get("Last-Modified")
+    if lastMod != mtime.Format(http.TimeFormat) {
+        t.Fatalf("Last-Modified %q, want %q", lastMod, mtime.Format(http.TimeFormat))
+    }
+
+    for _, tt := range []struct {
+        name   string
+        header []string
+        want   int
+    }{
+        {"echoed", []string{"If-Modified-Since", lastMod}, http.StatusNotModified},
+        {"later", []string{"If-Modified-Since",
+            mtime.Add(time.Hour).Format(http.TimeFormat)}, http.StatusNotModified},
+        {"earlier", []string{"If-Modified-Since",
+            mtime.Add(-time.Second).Format(http.TimeFormat)}, http.StatusOK},
+        {"malformed", []string{"If-Modified-Since", "yesterday"}, http.StatusOK},
+        {"etag wins", []string{"If-Modified-Since", lastMod,
+            "If-None-Match", `"stale"`}, http.StatusOK},
+    } {
+        rec := get(h, "/app.js", tt.header...)
+        if rec.Code != tt.want {
+            t.Errorf("%s: %d, want %d", tt.name, rec.Code, tt.want)
+        }
+        if tt.want == http.StatusNotModified && rec.Body.Len() != 0 {
+            t.Errorf("%s: 304 with a %d byte body", tt.name, rec.Body.Len())
+        }
+    }
+}
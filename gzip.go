@@ -0,0 +1,239 @@
+package main
+
+import (
+    "compress/gzip"
+    "mime"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+var compressibleTypes = map[string]bool{
+    "application/wasm":       true,
+    "text/javascript":        true,
+    "application/javascript": true,
+    "text/html":              true,
+    "text/css":               true,
+    "application/json":       true,
+    "image/svg+xml":          true,
+}
+
+var gzipWriters = sync.Pool{
+    New: func() any { return gzip.NewWriter(nil) },
+}
+
+// gzipHandler compresses compressible responses of at least minSize bytes
+// for clients that accept gzip.
+func gzipHandler(next http.Handler, minSize int) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        addVary(w.Header(), "Accept-Encoding")
+        // Byte ranges refer to the identity encoding, so compressing a
+        // partial response would corrupt it.
+        if r.Header.Get("Range") != "" ||
+                negotiateEncoding(r.Header.Get("Accept-Encoding"), "gzip") == "" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        gw := &gzipResponseWriter{
+            ResponseWriter: w,
+            minSize:        minSize,
+            weakMatch:      strings.Contains(r.Header.Get("If-None-Match"), "W/"),
+        }
+        defer gw.close()
+        next.ServeHTTP(gw, r)
+    })
+}
+
+// negotiateEncoding picks the offer with the highest quality in the
+// Accept-Encoding header, preferring earlier offers on ties. It returns ""
+// when the response should not be encoded, because none of the offers are
+// acceptable or the client explicitly prefers identity over all of them.
+func negotiateEncoding(header string, offers ...string) string {
+    accepted := parseAcceptEncoding(header)
+    best, bestQ := "", 0.0
+    for _, enc := range offers {
+        if q := encodingQuality(accepted, enc); q > bestQ {
+            best, bestQ = enc, q
+        }
+    }
+    // Identity is always acceptable unless excluded, but only competes with
+    // the offers when the client ranks it.
+    identity, ok := accepted["identity"]
+    if !ok {
+        identity = accepted["*"]
+    }
+    if identity > bestQ {
+        return ""
+    }
+    return best
+}
+
+// encodingQuality looks up the quality of a content coding, which is that
+// of the * wildcard when it is not named.
+func encodingQuality(accepted map[string]float64, enc string) float64 {
+    if q, ok := accepted[enc]; ok {
+        return q
+    }
+    return accepted["*"]
+}
+
+// parseAcceptEncoding maps the codings in an Accept-Encoding header to their
+// q-values, which default to 1. Malformed q-values count as 0.
+func parseAcceptEncoding(header string) map[string]float64 {
+    accepted := map[string]float64{}
+    for _, part := range strings.Split(header, ",") {
+        name, params, _ := strings.Cut(part, ";")
+        name = strings.ToLower(strings.TrimSpace(name))
+        if name == "" {
+            continue
+        }
+        q := 1.0
+        for _, param := range strings.Split(params, ";") {
+            k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+            if !strings.EqualFold(k, "q") {
+                continue
+            }
+            var err error
+            if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+                q = 0
+            }
+        }
+        accepted[name] = q
+    }
+    return accepted
+}
+
+// addVary adds field to the Vary header unless it is already listed.
+func addVary(h http.Header, field string) {
+    for _, v := range h.Values("Vary") {
+        for _, f := range strings.Split(v, ",") {
+            if strings.EqualFold(strings.TrimSpace(f), field) {
+                return
+            }
+        }
+    }
+    h.Add("Vary", field)
+}
+
+// gzipResponseWriter holds back the response until it has seen enough of
+// the body to decide whether compressing it is worthwhile.
+type gzipResponseWriter struct {
+    http.ResponseWriter
+    minSize   int
+    // weakMatch is set when the client revalidates with a weak ETag, as
+    // held by a compressed response, so that a 304 repeats the weak tag.
+    weakMatch bool
+    status    int
+    buf       []byte
+    decided   bool
+    gz        *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+    if w.decided || w.status != 0 {
+        w.ResponseWriter.WriteHeader(status)
+        return
+    }
+    w.status = status
+    if status == http.StatusNotModified && w.weakMatch {
+        weakenETag(w.Header())
+    }
+    if status != http.StatusOK || !w.compressible() {
+        w.decide(false)
+    } else if n, err := strconv.Atoi(w.Header().Get("Content-Length"));
+            err == nil && n < w.minSize {
+        w.decide(false)
+    }
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+    if w.status == 0 {
+        w.WriteHeader(http.StatusOK)
+    }
+    if !w.decided {
+        w.buf = append(w.buf, p...)
+        if len(w.buf) < w.minSize {
+            return len(p), nil
+        }
+        w.decide(true)
+        return len(p), nil
+    }
+    if w.gz != nil {
+        return w.gz.Write(p)
+    }
+    return w.ResponseWriter.Write(p)
+}
+
+func (w *gzipResponseWriter) Flush() {
+    if !w.decided {
+        if w.status == 0 {
+            w.WriteHeader(http.StatusOK)
+        }
+        if !w.decided {
+            w.decide(true)
+        }
+    }
+    if w.gz != nil {
+        w.gz.Flush()
+    }
+    http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+func (w *gzipResponseWriter) compressible() bool {
+    h := w.Header()
+    if h.Get("Content-Encoding") != "" {
+        return false
+    }
+    mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+    return err == nil && compressibleTypes[mediaType]
+}
+
+// decide sends the held back header and buffered body, compressed or not.
+func (w *gzipResponseWriter) decide(compress bool) {
+    w.decided = true
+    if compress {
+        h := w.Header()
+        h.Set("Content-Encoding", "gzip")
+        h.Del("Content-Length")
+        weakenETag(h)
+        w.gz = gzipWriters.Get().(*gzip.Writer)
+        w.gz.Reset(w.ResponseWriter)
+    }
+    if w.status != 0 {
+        w.ResponseWriter.WriteHeader(w.status)
+    }
+    if len(w.buf) == 0 {
+        return
+    }
+    if w.gz != nil {
+        w.gz.Write(w.buf)
+    } else {
+        w.ResponseWriter.Write(w.buf)
+    }
+    w.buf = nil
+}
+
+// weakenETag marks a strong ETag weak, since it names the identity bytes
+// and not those of a response compressed on the fly. If-None-Match uses
+// weak comparison, so revalidating with the weak tag still finds a match.
+func weakenETag(h http.Header) {
+    if tag := h.Get("ETag"); strings.HasPrefix(tag, `"`) {
+        h.Set("ETag", "W/"+tag)
+    }
+}
+
+func (w *gzipResponseWriter) close() {
+    if !w.decided {
+        w.decide(false)
+    }
+    if w.gz != nil {
+        w.gz.Close()
+        gzipWriters.Put(w.gz)
+        w.gz = nil
+    }
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "io"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestGzip(t *testing.T) {
+    if err := registerMimeTypes(); err != nil {
+        t.Fatal(err)
+    }
+    wasm := strings.Repeat("\x00asm\x01\x00\x00\x00", 512)
+    root := writeTree(t, map[string]string{
+        "app.wasm":  wasm,
+        "small.js":  "0",
+        "image.png": strings.Repeat("\x89PNG", 512),
+    })
+    h := gzipHandler(newTestFileHandler(t, root), 1024)
+
+    rec := get(h, "/app.wasm", "Accept-Encoding", "gzip")
+    if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+        t.Fatalf("Content-Encoding %q, want gzip", got)
+    }
+    if got := rec.Header().Get("Content-Length"); got != "" {
+        t.Errorf("Content-Length %s sent with a compressed body", got)
+    }
+    if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+        t.Errorf("Vary %q", got)
+    }
+    zr, err := gzip.NewReader(rec.Body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    body, err := io.ReadAll(zr)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(body, []byte(wasm)) {
+        t.Error("decompressed body differs from app.wasm")
+    }
+
+    for _, tt := range []struct {
+        target, accept, why string
+    }{
+        {"/app.wasm", "", "without Accept-Encoding"},
+        {"/app.wasm", "br", "without gzip in Accept-Encoding"},
+        {"/small.js", "gzip", "below the minimum size"},
+        {"/image.png", "gzip", "for an incompressible type"},
+    } {
+        rec := get(h, tt.target, "Accept-Encoding", tt.accept)
+        if got := rec.Header().Get("Content-Encoding"); got != "" {
+            t.Errorf("%s compressed %s", tt.target, tt.why)
+        }
+        if rec.Code != http.StatusOK || rec.Header().Get("Content-Length") == "" {
+            t.Errorf("%s %s: %d with Content-Length %q", tt.target, tt.why,
+                rec.Code, rec.Header().Get("Content-Length"))
+        }
+    }
+}
+
+func TestRange(t *testing.T) {
+    if err := registerMimeTypes(); err != nil {
+        t.Fatal(err)
+    }
+    wasm := strings.Repeat("0123456789", 300)
+    root := writeTree(t, map[string]string{"app.wasm": wasm})
+    h := gzipHandler(newTestFileHandler(t, root), 100)
+
+    rec := get(h, "/app.wasm", "Range", "bytes=0-99", "Accept-Encoding", "gzip")
+    if rec.Code != http.StatusPartialContent {
+        t.Fatalf("status %d, want 206", rec.Code)
+    }
+    if got := rec.Header().Get("Content-Range"); got != "bytes 0-99/3000" {
+        t.Errorf("Content-Range %q", got)
+    }
+    if got := rec.Header().Get("Content-Encoding"); got != "" {
+        t.Errorf("partial response encoded with %s", got)
+    }
+    if rec.Body.Len() != 100 || rec.Body.String() != wasm[:100] {
+        t.Errorf("body %q, want the first 100 bytes", rec.Body)
+    }
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+    for _, tt := range []struct {
+        header string
+        want   string
+    }{
+        {"br;q=1.0, gzip;q=0.5", "br"},
+        {"gzip;q=0.5, br;q=1.0", "br"},
+        // Ties go to the earlier offer, not the earlier header entry.
+        {"gzip, br", "br"},
+        {"gzip;q=0", ""},
+        {"gzip;q=0, *", "br"},
+        {"*", "br"},
+        {"*;q=0", ""},
+        {"identity", ""},
+        {"identity;q=0.5, gzip", "gzip"},
+        {"gzip;q=0.5, identity", ""},
+        {"GZIP", "gzip"},
+        {"gzip;q=bogus", ""},
+        {"", ""},
+    } {
+        if got := negotiateEncoding(tt.header, "br", "gzip"); got != tt.want {
+            t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+        }
+    }
+}
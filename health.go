@@ -0,0 +1,33 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sync/atomic"
+)
+
+// health answers liveness and readiness probes. The server is live as soon
+// as it accepts connections but only ready once startup has completed.
+type health struct {
+    ready atomic.Bool
+}
+
+func (h *health) healthz(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (h *health) readyz(w http.ResponseWriter, r *http.Request) {
+    if !h.ready.Load() {
+        writeJSON(w, http.StatusServiceUnavailable,
+            map[string]string{"status": "unavailable"})
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+// call sends method and target to h and decodes the JSON response into v.
+func call(t *testing.T, h http.Handler, method, target string, v any) int {
+    t.Helper()
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+    if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+        t.Errorf("%s %s: Content-Type %q", method, target, ct)
+    }
+    if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+        t.Fatalf("%s %s: %v: %s", method, target, err, rec.Body)
+    }
+    return rec.Code
+}
+
+func TestHealth(t *testing.T) {
+    probes := &health{}
+    healthz, readyz := http.HandlerFunc(probes.healthz), http.HandlerFunc(probes.readyz)
+    for _, tt := range []struct {
+        name    string
+        ready   bool
+        handler http.Handler
+        code    int
+        status  string
+    }{
+        {"healthz while starting", false, healthz, http.StatusOK, "ok"},
+        {"readyz while starting", false, readyz, http.StatusServiceUnavailable, "unavailable"},
+        {"healthz when ready", true, healthz, http.StatusOK, "ok"},
+        {"readyz when ready", true, readyz, http.StatusOK, "ok"},
+    } {
+        probes.ready.Store(tt.ready)
+        var resp struct{ Status string }
+        if code := call(t, tt.handler, "GET", "/", &resp); code != tt.code || resp.Status != tt.status {
+            t.Errorf("%s: %d %q, want %d %q", tt.name, code, resp.Status, tt.code, tt.status)
+        }
+    }
+}
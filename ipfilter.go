@@ -0,0 +1,56 @@
+package main
+
+import (
+    "fmt"
+    "net"
+    "net/http"
+    "strings"
+)
+
+// parseCIDRs parses networks in CIDR notation. A bare address stands for
+// the network holding only that address.
+func parseCIDRs(specs []string) ([]*net.IPNet, error) {
+    var nets []*net.IPNet
+    for _, spec := range specs {
+        if !strings.Contains(spec, "/") {
+            ip := net.ParseIP(spec)
+            if ip == nil {
+                return nil, fmt.Errorf("invalid address %q", spec)
+            }
+            bits := 8 * net.IPv6len
+            if ip4 := ip.To4(); ip4 != nil {
+                ip, bits = ip4, 8*net.IPv4len
+            }
+            nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+            continue
+        }
+        _, n, err := net.ParseCIDR(spec)
+        if err != nil {
+            return nil, err
+        }
+        nets = append(nets, n)
+    }
+    return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+    for _, n := range nets {
+        if n.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// ipFilter forbids requests from clients outside the allowed networks, when
+// there are any, or inside a denied one.
+func ipFilter(next http.Handler, allow, deny []*net.IPNet, trustProxy bool) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ip := net.ParseIP(clientIP(r, trustProxy))
+        if (len(allow) > 0 && !containsIP(allow, ip)) || containsIP(deny, ip) {
+            http.Error(w, "403 Forbidden", http.StatusForbidden)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
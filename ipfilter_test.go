@@ -0,0 +1,59 @@
+package main
+
+import (
+    "crypto/tls"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestIPFilter(t *testing.T) {
+    allow, err := parseCIDRs([]string{"10.0.0.0/8", "192.168.1.5"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    deny, err := parseCIDRs([]string{"10.1.0.0/16"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    h := ipFilter(http.NotFoundHandler(), allow, deny, false)
+    for _, tt := range []struct {
+        remote string
+        want   int
+    }{
+        {"10.2.3.4:5000", http.StatusNotFound},
+        {"192.168.1.5:5000", http.StatusNotFound},
+        {"192.168.1.6:5000", http.StatusForbidden},
+        {"10.1.2.3:5000", http.StatusForbidden},
+        {"[::1]:5000", http.StatusForbidden},
+    } {
+        req := httptest.NewRequest("GET", "/", nil)
+        req.RemoteAddr = tt.remote
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+        if rec.Code != tt.want {
+            t.Errorf("%s: status %d, want %d", tt.remote, rec.Code, tt.want)
+        }
+    }
+
+    if _, err := parseCIDRs([]string{"1.2.3/8"}); err == nil {
+        t.Error("parsed 1.2.3/8")
+    }
+}
+
+func TestIPFilterWebTransport(t *testing.T) {
+    deny, _ := parseCIDRs([]string{"192.0.2.0/24"})
+    middleware := func(h http.Handler) http.Handler {
+        return ipFilter(h, nil, deny, false)
+    }
+    wt := newWebTransport("127.0.0.1:0", &tls.Config{}, newRelay(4, 0), middleware)
+
+    req := httptest.NewRequest("CONNECT", "/wt?room=r", nil)
+    req.Proto = "webtransport"
+    req.RemoteAddr = "192.0.2.1:5000"
+    rec := httptest.NewRecorder()
+    wt.H3.Handler.ServeHTTP(rec, req)
+    if rec.Code != http.StatusForbidden {
+        t.Errorf("status %d, want 403", rec.Code)
+    }
+}
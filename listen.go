@@ -0,0 +1,77 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "net"
+    "os"
+    "strings"
+    "syscall"
+
+    "golang.org/x/net/netutil"
+)
+
+// listenConfig says how servers open their listeners.
+type listenConfig struct {
+    // maxConns bounds the connections open at once when positive.
+    maxConns int
+    // socketMode is the permissions given to UNIX sockets.
+    socketMode fs.FileMode
+}
+
+// listen opens a listener on addr, which is a TCP address or unix:/path for
+// a UNIX socket. When maxConns is positive, at most that many connections
+// are open at once and further clients wait in the kernel's accept queue
+// until one closes. A connection holds its slot for as long as it is open,
+// whether it is serving a request, idling between keep-alive requests or
+// carrying a WebSocket, so a handful of idle browsers can keep new clients
+// waiting for up to -idle-timeout. The limit counts connections rather
+// than requests because file descriptors are what run out.
+func (lc listenConfig) listen(addr string) (net.Listener, error) {
+    var ln net.Listener
+    var err error
+    if sock, ok := strings.CutPrefix(addr, "unix:"); ok {
+        ln, err = listenUnix(sock, lc.socketMode)
+    } else {
+        ln, err = net.Listen("tcp", addr)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if lc.maxConns > 0 {
+        ln = netutil.LimitListener(ln, lc.maxConns)
+    }
+    return ln, nil
+}
+
+// listenUnix listens on a UNIX socket at path, first removing a socket left
+// behind by a server that did not shut down cleanly. The socket file is
+// removed again when the listener is closed.
+func listenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+    if info, err := os.Lstat(path); err == nil {
+        if info.Mode().Type() != fs.ModeSocket {
+            return nil, fmt.Errorf("%s exists and is not a socket", path)
+        }
+        conn, err := net.Dial("unix", path)
+        if err == nil {
+            conn.Close()
+            return nil, fmt.Errorf("%s is in use by another server", path)
+        }
+        if !errors.Is(err, syscall.ECONNREFUSED) {
+            return nil, err
+        }
+        if err := os.Remove(path); err != nil {
+            return nil, err
+        }
+    }
+    ln, err := net.Listen("unix", path)
+    if err != nil {
+        return nil, err
+    }
+    if err := os.Chmod(path, mode); err != nil {
+        ln.Close()
+        return nil, err
+    }
+    return ln, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestMaxConns(t *testing.T) {
+    const maxConns = 2
+    ln, err := listenConfig{maxConns: maxConns}.listen("127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    accepted := make(chan net.Conn, maxConns+1)
+    go func() {
+        for {
+            conn, err := ln.Accept()
+            if err != nil {
+                return
+            }
+            accepted <- conn
+        }
+    }()
+
+    for range maxConns + 1 {
+        conn, err := net.Dial("tcp", ln.Addr().String())
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer conn.Close()
+    }
+    var open []net.Conn
+    for range maxConns {
+        select {
+        case conn := <-accepted:
+            open = append(open, conn)
+        case <-time.After(5 * time.Second):
+            t.Fatal("connection within the limit was not accepted")
+        }
+    }
+    select {
+    case <-accepted:
+        t.Fatal("accepted more than maxConns connections")
+    case <-time.After(100 * time.Millisecond):
+    }
+
+    // Closing a connection frees its slot for the one waiting.
+    open[0].Close()
+    select {
+    case conn := <-accepted:
+        conn.Close()
+    case <-time.After(5 * time.Second):
+        t.Error("waiting connection was not accepted after a slot freed")
+    }
+    open[1].Close()
+}
+
+func TestListenUnix(t *testing.T) {
+    // Socket paths are limited to about 100 bytes, which t.TempDir can
+    // exceed, so keep the name short.
+    dir, err := os.MkdirTemp("", "thierd")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    sock := filepath.Join(dir, "s")
+
+    // A socket left behind by an unclean shutdown is replaced.
+    stale, err := net.Listen("unix", sock)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stale.(*net.UnixListener).SetUnlinkOnClose(false)
+    stale.Close()
+
+    ln, err := listenConfig{socketMode: 0o660}.listen("unix:" + sock)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if info, err := os.Stat(sock); err != nil || info.Mode().Perm() != 0o660 {
+        t.Errorf("socket mode: %v, %v; want 0660", info.Mode(), err)
+    }
+    if _, err := listenUnix(sock, 0o660); err == nil {
+        t.Error("listened on a socket another server is using")
+    }
+
+    srv := &http.Server{Handler: hello}
+    go srv.Serve(ln)
+    client := &http.Client{Transport: &http.Transport{
+        DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+            var d net.Dialer
+            return d.DialContext(ctx, "unix", sock)
+        },
+    }}
+    resp, err := client.Get("http://thierd/")
+    if err != nil {
+        t.Fatal(err)
+    }
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK || string(body) != "<p>hello</p>" {
+        t.Errorf("%d %q", resp.StatusCode, body)
+    }
+
+    srv.Close()
+    if _, err := os.Lstat(sock); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("socket left behind after close: %v", err)
+    }
+
+    if err := os.WriteFile(sock, nil, 0o644); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := listenUnix(sock, 0o660); err == nil {
+        t.Error("replaced a regular file with a socket")
+    }
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+    "cmp"
+    "html/template"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "slices"
+    "time"
+)
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Index of {{.Path}}</title>
+</head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{- if ne .Path "/"}}
+<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td><a href="{{.URL}}">{{.Name}}</a></td><td>{{if .IsDir}}-{{else}}{{.Size}}{{end}}</td><td>{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+type listingEntry struct {
+    Name    string
+    URL     string
+    Size    int64
+    ModTime time.Time
+    IsDir   bool
+}
+
+// serveListing renders the merged contents of the directory at urlPath
+// across all roots, directories first and then files by name.
+func (h *fileHandler) serveListing(w http.ResponseWriter, r *http.Request, urlPath string) {
+    seen := map[string]bool{}
+    var entries []listingEntry
+    for _, root := range h.roots {
+        dir, err := resolveIn(root, urlPath)
+        if err != nil {
+            h.notFound(w, r)
+            return
+        }
+        des, err := os.ReadDir(dir)
+        if err != nil {
+            continue
+        }
+        for _, de := range des {
+            if seen[de.Name()] {
+                continue
+            }
+            seen[de.Name()] = true
+            info, err := de.Info()
+            if err != nil {
+                continue
+            }
+            e := listingEntry{
+                Name:    de.Name(),
+                URL:     (&url.URL{Path: de.Name()}).String(),
+                Size:    info.Size(),
+                ModTime: info.ModTime(),
+                IsDir:   de.IsDir(),
+            }
+            if e.IsDir {
+                e.Name += "/"
+                e.URL += "/"
+            }
+            entries = append(entries, e)
+        }
+    }
+    slices.SortFunc(entries, func(a, b listingEntry) int {
+        if a.IsDir != b.IsDir {
+            if a.IsDir {
+                return -1
+            }
+            return 1
+        }
+        return cmp.Compare(a.Name, b.Name)
+    })
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-cache")
+    err := listingTemplate.Execute(w, struct {
+        Path    string
+        Entries []listingEntry
+    }{urlPath, entries})
+    if err != nil {
+        log.Printf("rendering listing of %s: %v", urlPath, err)
+    }
+}
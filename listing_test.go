@@ -0,0 +1,56 @@
+package main
+
+import (
+    "net/http"
+    "regexp"
+    "strings"
+    "testing"
+)
+
+func TestListing(t *testing.T) {
+    first := writeTree(t, map[string]string{
+        "files/b.wasm":      "bb",
+        "files/zdir/x":      "",
+        "files/<b>&amp.txt": "",
+    })
+    second := writeTree(t, map[string]string{
+        "files/a.js":   "a",
+        "files/b.wasm": "shadowed",
+        "files/adir/y": "",
+    })
+    h := newTestFileHandler(t, first, second)
+    h.listing = true
+
+    rec := get(h, "/files/")
+    if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+        t.Fatalf("%d %q", rec.Code, rec.Header().Get("Content-Type"))
+    }
+    body := rec.Body.String()
+    links := regexp.MustCompile(`<a href="([^"]*)">([^<]*)</a>`).FindAllStringSubmatch(body, -1)
+    var got []string
+    for _, l := range links {
+        got = append(got, l[1]+" "+l[2])
+    }
+    want := []string{
+        "../ ../",
+        "adir/ adir/",
+        "zdir/ zdir/",
+        "%3Cb%3E&amp;amp.txt &lt;b&gt;&amp;amp.txt",
+        "a.js a.js",
+        "b.wasm b.wasm",
+    }
+    if strings.Join(got, "\n") != strings.Join(want, "\n") {
+        t.Errorf("entries:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+    }
+    if !strings.Contains(body, "<td>2</td>") {
+        t.Error("b.wasm is not listed with the size of the first root's copy")
+    }
+
+    if rec := get(h, "/files/adir/../../"); rec.Code != http.StatusNotFound {
+        t.Errorf("listing outside the root: %d", rec.Code)
+    }
+    h.listing = false
+    if rec := get(h, "/files/"); rec.Code != http.StatusNotFound {
+        t.Errorf("without -listing: %d, want 404", rec.Code)
+    }
+}
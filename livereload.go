@@ -0,0 +1,170 @@
+package main
+
+import (
+    "bytes"
+    "io/fs"
+    "log"
+    "net/http"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+    "github.com/gorilla/websocket"
+)
+
+// The client is served as a separate script rather than inlined so that it
+// is allowed by the default Content-Security-Policy.
+const (
+    liveReloadTag = `<script src="/livereload.js"></script>
+`
+    liveReloadClient = `(() => {
+const proto = location.protocol === "https:" ? "wss://" : "ws://";
+const ws = new WebSocket(proto + location.host + "/livereload");
+ws.onmessage = () => location.reload();
+})();
+`
+)
+
+func serveLiveReloadClient(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Write([]byte(liveReloadClient))
+}
+
+// liveReload tells connected pages to reload whenever the build output
+// changes.
+type liveReload struct {
+    upgrader websocket.Upgrader
+
+    mu      sync.Mutex
+    clients map[*websocket.Conn]struct{}
+}
+
+func newLiveReload() *liveReload {
+    return &liveReload{clients: map[*websocket.Conn]struct{}{}}
+}
+
+func (lr *liveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    conn, err := upgrade(&lr.upgrader, w, r)
+    if err != nil {
+        return
+    }
+    lr.mu.Lock()
+    lr.clients[conn] = struct{}{}
+    lr.mu.Unlock()
+    defer func() {
+        lr.mu.Lock()
+        delete(lr.clients, conn)
+        lr.mu.Unlock()
+        conn.Close()
+    }()
+
+    for {
+        if _, _, err := conn.ReadMessage(); err != nil {
+            return
+        }
+    }
+}
+
+func (lr *liveReload) broadcast() {
+    lr.mu.Lock()
+    defer lr.mu.Unlock()
+    for conn := range lr.clients {
+        conn.SetWriteDeadline(time.Now().Add(writeWait))
+        if err := conn.WriteMessage(websocket.TextMessage, []byte("reload")); err != nil {
+            conn.Close()
+        }
+    }
+}
+
+// watch watches root and its subdirectories, broadcasting a single reload
+// once a burst of changes has been quiet for the debounce interval.
+func (lr *liveReload) watch(root string, debounce time.Duration) (*fsnotify.Watcher, error) {
+    watcher, err := fsnotify.NewWatcher()
+    if err != nil {
+        return nil, err
+    }
+    err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+        if err != nil || !d.IsDir() {
+            return err
+        }
+        return watcher.Add(p)
+    })
+    if err != nil {
+        watcher.Close()
+        return nil, err
+    }
+
+    changes := make(chan struct{}, 1)
+    go debounceEvents(changes, debounce, lr.broadcast)
+    go func() {
+        defer close(changes)
+        for {
+            select {
+            case ev, ok := <-watcher.Events:
+                if !ok {
+                    return
+                }
+                if !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Create) {
+                    continue
+                }
+                if ev.Has(fsnotify.Create) {
+                    watchNewDir(watcher, ev.Name)
+                }
+                select {
+                case changes <- struct{}{}:
+                default:
+                }
+            case err, ok := <-watcher.Errors:
+                if !ok {
+                    return
+                }
+                log.Printf("livereload: %v", err)
+            }
+        }
+    }()
+    return watcher, nil
+}
+
+// watchNewDir starts watching a directory created after startup, such as a
+// fresh build output tree.
+func watchNewDir(watcher *fsnotify.Watcher, name string) {
+    filepath.WalkDir(name, func(p string, d fs.DirEntry, err error) error {
+        if err == nil && d.IsDir() {
+            watcher.Add(p)
+        }
+        return nil
+    })
+}
+
+// debounceEvents calls fire once after each burst of values on events,
+// where a burst ends after wait passes without a new value.
+func debounceEvents(events <-chan struct{}, wait time.Duration, fire func()) {
+    var timer <-chan time.Time
+    for {
+        select {
+        case _, ok := <-events:
+            if !ok {
+                return
+            }
+            timer = time.After(wait)
+        case <-timer:
+            timer = nil
+            fire()
+        }
+    }
+}
+
+// injectScript inserts script just before the closing body tag of an HTML
+// document, or appends it when there is none.
+func injectScript(doc []byte, script string) []byte {
+    i := bytes.LastIndex(bytes.ToLower(doc), []byte("</body>"))
+    if i < 0 {
+        return append(doc[:len(doc):len(doc)], script...)
+    }
+    out := make([]byte, 0, len(doc)+len(script))
+    out = append(out, doc[:i]...)
+    out = append(out, script...)
+    return append(out, doc[i:]...)
+}
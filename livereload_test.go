@@ -0,0 +1,88 @@
+package main
+
+import (
+    "fmt"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+func TestDebounceEvents(t *testing.T) {
+    events := make(chan struct{})
+    var fired atomic.Int32
+    done := make(chan struct{})
+    go func() {
+        debounceEvents(events, 50*time.Millisecond, func() { fired.Add(1) })
+        close(done)
+    }()
+
+    for range 10 {
+        events <- struct{}{}
+        time.Sleep(5 * time.Millisecond)
+    }
+    time.Sleep(200 * time.Millisecond)
+    if n := fired.Load(); n != 1 {
+        t.Errorf("fired %d times after one burst, want 1", n)
+    }
+    events <- struct{}{}
+    time.Sleep(200 * time.Millisecond)
+    if n := fired.Load(); n != 2 {
+        t.Errorf("fired %d times after two bursts, want 2", n)
+    }
+    close(events)
+    <-done
+}
+
+func TestLiveReload(t *testing.T) {
+    root := t.TempDir()
+    lr := newLiveReload()
+    watcher, err := lr.watch(root, 100*time.Millisecond)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer watcher.Close()
+    srv := httptest.NewServer(lr)
+    defer srv.Close()
+    conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    // Let the server register the connection before anything changes.
+    time.Sleep(50 * time.Millisecond)
+
+    // A build writes several files, one of them in a new directory.
+    for i := range 5 {
+        name := filepath.Join(root, fmt.Sprint(i))
+        if i == 4 {
+            name = filepath.Join(root, "sub", "app.wasm")
+            os.Mkdir(filepath.Dir(name), 0o755)
+        }
+        if err := os.WriteFile(name, []byte("x"), 0o644); err != nil {
+            t.Fatal(err)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    reloads := 0
+    conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+    for {
+        _, msg, err := conn.ReadMessage()
+        if err != nil {
+            break
+        }
+        if string(msg) != "reload" {
+            t.Errorf("message %q", msg)
+        }
+        reloads++
+    }
+    if reloads != 1 {
+        t.Errorf("%d reloads after one burst of changes, want 1", reloads)
+    }
+}
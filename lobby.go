@@ -0,0 +1,111 @@
+package main
+
+import (
+    "crypto/rand"
+    "errors"
+    "net/http"
+    "net/url"
+    "time"
+)
+
+const (
+    roomCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+    roomCodeLength   = 4
+)
+
+// createRoom reserves a new room under a random short code. The room lives
+// until its last player leaves, or expires if nobody ever joins.
+func (rl *relay) createRoom(opts roomOptions) (string, error) {
+    rl.mu.Lock()
+    defer rl.mu.Unlock()
+    buf := make([]byte, roomCodeLength)
+    for range 10 {
+        rand.Read(buf)
+        for i, b := range buf {
+            buf[i] = roomCodeAlphabet[int(b)%len(roomCodeAlphabet)]
+        }
+        code := string(buf)
+        if _, ok := rl.rooms[code]; ok {
+            continue
+        }
+        rl.rooms[code] = rl.newRoom(code, opts)
+        return code, nil
+    }
+    return "", errors.New("no free room codes")
+}
+
+// expireRooms periodically removes rooms that have had no players for ttl.
+func (rl *relay) expireRooms(ttl time.Duration) {
+    for now := range time.Tick(ttl / 2) {
+        rl.expire(now, ttl)
+    }
+}
+
+// expire removes the rooms that are empty and were created more than ttl
+// before now.
+func (rl *relay) expire(now time.Time, ttl time.Duration) {
+    rl.mu.Lock()
+    defer rl.mu.Unlock()
+    for code, rm := range rl.rooms {
+        rm.mu.Lock()
+        if rm.occupied() == 0 && now.Sub(rm.created) > ttl {
+            delete(rl.rooms, code)
+        }
+        rm.mu.Unlock()
+    }
+}
+
+func (rl *relay) handleCreateRoom(w http.ResponseWriter, r *http.Request) {
+    opts, err := parseRoomOptions(r.URL.Query())
+    if err != nil {
+        writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+        return
+    }
+    code, err := rl.createRoom(opts)
+    if err != nil {
+        writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+        return
+    }
+    writeJSON(w, http.StatusCreated, map[string]string{
+        "code": code,
+        "ws":   "/ws?room=" + url.QueryEscape(code),
+    })
+}
+
+func (rl *relay) handleRoomStatus(w http.ResponseWriter, r *http.Request) {
+    code := r.PathValue("code")
+    rl.mu.Lock()
+    rm := rl.rooms[code]
+    rl.mu.Unlock()
+    if rm == nil {
+        writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such room"})
+        return
+    }
+
+    rm.mu.Lock()
+    players, occupied := rm.connected(), rm.occupied()
+    host := -1
+    if rm.host != nil {
+        host = rm.host.index
+    }
+    rm.mu.Unlock()
+    status := "open"
+    if occupied >= rl.maxPlayers {
+        status = "full"
+    }
+    resp := map[string]any{
+        "code":       code,
+        "players":    players,
+        "maxPlayers": rl.maxPlayers,
+        "status":     status,
+        "mode":       "broadcast",
+    }
+    if rm.hosted {
+        resp["mode"] = "host"
+        resp["hostLeave"] = rm.hostLeave
+        if host >= 0 {
+            resp["host"] = host
+        }
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+    "net/http"
+    "testing"
+    "time"
+)
+
+func lobbyMux(rl *relay) *http.ServeMux {
+    mux := http.NewServeMux()
+    mux.HandleFunc("POST /rooms", rl.handleCreateRoom)
+    mux.HandleFunc("GET /rooms/{code}", rl.handleRoomStatus)
+    return mux
+}
+
+func TestCreateRoom(t *testing.T) {
+    rl := newRelay(2, 0)
+    mux := lobbyMux(rl)
+
+    var created struct{ Code, WS string }
+    if code := call(t, mux, "POST", "/rooms", &created); code != http.StatusCreated {
+        t.Fatalf("create: %d", code)
+    }
+    if len(created.Code) != roomCodeLength || created.WS != "/ws?room="+created.Code {
+        t.Errorf("create: %+v", created)
+    }
+
+    status := func() (players int, state string) {
+        var resp struct {
+            Players    int
+            MaxPlayers int
+            Status     string
+        }
+        if code := call(t, mux, "GET", "/rooms/"+created.Code, &resp); code != http.StatusOK {
+            t.Fatalf("status: %d", code)
+        }
+        if resp.MaxPlayers != 2 {
+            t.Errorf("maxPlayers %d, want 2", resp.MaxPlayers)
+        }
+        return resp.Players, resp.Status
+    }
+    if n, s := status(); n != 0 || s != "open" {
+        t.Errorf("empty room: %d players, %s", n, s)
+    }
+    rl.join(created.Code, roomOptions{}, &client{})
+    if n, s := status(); n != 1 || s != "open" {
+        t.Errorf("one player: %d players, %s", n, s)
+    }
+    rl.join(created.Code, roomOptions{}, &client{})
+    if n, s := status(); n != 2 || s != "full" {
+        t.Errorf("two players: %d players, %s", n, s)
+    }
+
+    var missing struct{ Error string }
+    if code := call(t, mux, "GET", "/rooms/ZZZZ", &missing); code != http.StatusNotFound ||
+            missing.Error == "" {
+        t.Errorf("unknown room: %d %+v", code, missing)
+    }
+    var bad struct{ Error string }
+    if code := call(t, mux, "POST", "/rooms?mode=chaos", &bad); code != http.StatusBadRequest ||
+            bad.Error == "" {
+        t.Errorf("bad mode: %d %+v", code, bad)
+    }
+}
+
+func TestCreateRoomNoCodes(t *testing.T) {
+    rl := newRelay(2, 0)
+    // Take every code so that createRoom has to give up.
+    code := make([]byte, roomCodeLength)
+    var fill func(i int)
+    fill = func(i int) {
+        if i == len(code) {
+            rl.rooms[string(code)] = &room{}
+            return
+        }
+        for j := range len(roomCodeAlphabet) {
+            code[i] = roomCodeAlphabet[j]
+            fill(i + 1)
+        }
+    }
+    fill(0)
+
+    var resp struct{ Error string }
+    if status := call(t, lobbyMux(rl), "POST", "/rooms", &resp); status != http.StatusServiceUnavailable ||
+            resp.Error == "" {
+        t.Errorf("%d %+v, want 503 with an error", status, resp)
+    }
+}
+
+func TestExpireRooms(t *testing.T) {
+    rl := newRelay(2, 0)
+    abandoned, _ := rl.createRoom(roomOptions{})
+    joined, _ := rl.createRoom(roomOptions{})
+    fresh, _ := rl.createRoom(roomOptions{})
+    rl.join(joined, roomOptions{}, &client{})
+    for _, code := range []string{abandoned, joined} {
+        rl.rooms[code].created = time.Now().Add(-time.Hour)
+    }
+
+    rl.expire(time.Now(), time.Minute)
+    for code, want := range map[string]bool{abandoned: false, joined: true, fresh: true} {
+        if _, ok := rl.rooms[code]; ok != want {
+            t.Errorf("room %s kept: %v, want %v", code, ok, want)
+        }
+    }
+}
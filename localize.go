@@ -0,0 +1,86 @@
+package main
+
+import (
+    "net/http"
+    "os"
+    "path"
+    "slices"
+    "strings"
+
+    "golang.org/x/text/language"
+)
+
+// localizedIndexes finds the index.<lang>.html files in a directory of the
+// roots, by language.
+func (h *fileHandler) localizedIndexes(dir string) map[language.Tag]string {
+    found := map[language.Tag]string{}
+    for _, root := range h.roots {
+        name, err := resolveIn(root, dir)
+        if err != nil {
+            continue
+        }
+        entries, err := os.ReadDir(name)
+        if err != nil {
+            continue
+        }
+        for _, e := range entries {
+            if tag, ok := indexLanguage(e.Name()); ok && !e.IsDir() {
+                if _, dup := found[tag]; !dup {
+                    found[tag] = e.Name()
+                }
+            }
+        }
+    }
+    return found
+}
+
+// indexLanguage parses the language out of a localized index name such as
+// index.fr.html.
+func indexLanguage(base string) (language.Tag, bool) {
+    lang, ok := strings.CutPrefix(base, "index.")
+    if !ok {
+        return language.Und, false
+    }
+    if lang, ok = strings.CutSuffix(lang, ".html"); !ok {
+        return language.Und, false
+    }
+    tag, err := language.Parse(lang)
+    return tag, err == nil
+}
+
+// isIndex reports whether a file name is index.html or a localized copy.
+func isIndex(base string) bool {
+    _, localized := indexLanguage(base)
+    return base == "index.html" || localized
+}
+
+// index picks the index file to serve for a directory. When the directory
+// has localized copies the best match for the client's Accept-Language is
+// chosen, falling back to the default language and then to index.html.
+func (h *fileHandler) index(w http.ResponseWriter, r *http.Request, dir string) string {
+    localized := h.localizedIndexes(dir)
+    if len(localized) == 0 {
+        return "index.html"
+    }
+    addVary(w.Header(), "Accept-Language")
+
+    tags := make([]language.Tag, 0, len(localized))
+    for tag := range localized {
+        tags = append(tags, tag)
+    }
+    slices.SortFunc(tags, func(a, b language.Tag) int {
+        return strings.Compare(a.String(), b.String())
+    })
+    desired, _, _ := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+    _, i, conf := language.NewMatcher(tags).Match(desired...)
+    best := tags[i]
+    if conf == language.No {
+        if _, ok := localized[h.defaultLang]; ok {
+            best = h.defaultLang
+        } else if h.exists(path.Join(dir, "index.html")) {
+            return "index.html"
+        }
+    }
+    w.Header().Set("Content-Language", best.String())
+    return localized[best]
+}
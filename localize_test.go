@@ -0,0 +1,46 @@
+package main
+
+import (
+    "slices"
+    "testing"
+
+    "golang.org/x/text/language"
+)
+
+func TestLocalizedIndex(t *testing.T) {
+    both := writeTree(t, map[string]string{
+        "index.html":    "plain",
+        "index.en.html": "english",
+        "index.fr.html": "french",
+    })
+    noDefault := writeTree(t, map[string]string{
+        "index.html":    "plain",
+        "index.fr.html": "french",
+    })
+    plain := writeTree(t, map[string]string{"index.html": "plain"})
+
+    for _, tt := range []struct {
+        name, root, accept string
+        body, lang         string
+    }{
+        {"exact", both, "fr", "french", "fr"},
+        {"regional", both, "fr-CA, de;q=0.5", "french", "fr"},
+        {"default", both, "de", "english", "en"},
+        {"no default", noDefault, "de", "plain", ""},
+        {"not localized", plain, "fr", "plain", ""},
+    } {
+        h := newTestFileHandler(t, tt.root)
+        h.defaultLang = language.English
+        rec := get(h, "/", "Accept-Language", tt.accept)
+        if body := rec.Body.String(); body != tt.body {
+            t.Errorf("%s: served %q, want %q", tt.name, body, tt.body)
+        }
+        if lang := rec.Header().Get("Content-Language"); lang != tt.lang {
+            t.Errorf("%s: Content-Language %q, want %q", tt.name, lang, tt.lang)
+        }
+        vary := rec.Header().Values("Vary")
+        if varies := slices.Contains(vary, "Accept-Language"); varies != (tt.root != plain) {
+            t.Errorf("%s: Vary %q", tt.name, vary)
+        }
+    }
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "log/slog"
+    "net"
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// accessLogger records a single completed request.
+type accessLogger func(r *http.Request, status int, size int64, d time.Duration)
+
+func newAccessLogger(format string, out io.Writer) (accessLogger, error) {
+    switch format {
+    case "text":
+        return textAccessLog(out), nil
+    case "json":
+        return jsonAccessLog(out), nil
+    case "none":
+        return nil, nil
+    }
+    return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// textAccessLog writes lines in the combined log format with the request
+// duration and ID appended.
+func textAccessLog(out io.Writer) accessLogger {
+    return func(r *http.Request, status int, size int64, d time.Duration) {
+        host, _, err := net.SplitHostPort(r.RemoteAddr)
+        if err != nil {
+            host = r.RemoteAddr
+        }
+        id := requestID(r.Context())
+        if id == "" {
+            id = "-"
+        }
+        fmt.Fprintf(out, "%s - - [%s] %q %d %d %q %q %s %s\n",
+            host,
+            time.Now().Format("02/Jan/2006:15:04:05 -0700"),
+            r.Method+" "+r.URL.RequestURI()+" "+r.Proto,
+            status, size, r.Referer(), r.UserAgent(), d, id)
+    }
+}
+
+func jsonAccessLog(out io.Writer) accessLogger {
+    logger := slog.New(slog.NewJSONHandler(out, nil))
+    return func(r *http.Request, status int, size int64, d time.Duration) {
+        logger.LogAttrs(context.Background(), slog.LevelInfo, "request",
+            slog.String("method", r.Method),
+            slog.String("path", r.URL.Path),
+            slog.String("remote", r.RemoteAddr),
+            slog.Int("status", status),
+            slog.Int64("bytes", size),
+            slog.Duration("duration", d),
+            slog.String("request_id", requestID(r.Context())),
+        )
+    }
+}
+
+// asyncWriter hands writes to a background goroutine so that a slow disk
+// or terminal never holds up the request being logged. Writes are dropped,
+// and counted, when the queue is full or the writer has been closed.
+type asyncWriter struct {
+    out     io.Writer
+    done    chan struct{}
+    dropped atomic.Int64
+
+    mu     sync.Mutex
+    queue  chan []byte
+    closed bool
+}
+
+func newAsyncWriter(out io.Writer, size int) *asyncWriter {
+    w := &asyncWriter{
+        out:   out,
+        queue: make(chan []byte, size),
+        done:  make(chan struct{}),
+    }
+    go func() {
+        defer close(w.done)
+        for p := range w.queue {
+            w.out.Write(p)
+        }
+    }()
+    return w
+}
+
+func (w *asyncWriter) Write(p []byte) (int, error) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if w.closed {
+        w.dropped.Add(1)
+        return len(p), nil
+    }
+    select {
+    case w.queue <- bytes.Clone(p):
+    default:
+        w.dropped.Add(1)
+    }
+    return len(p), nil
+}
+
+// Close waits until everything queued has been written. Handlers that were
+// still running when shutdown timed out may log afterwards; those lines are
+// dropped.
+func (w *asyncWriter) Close() error {
+    w.mu.Lock()
+    if w.closed {
+        w.mu.Unlock()
+        return nil
+    }
+    w.closed = true
+    close(w.queue)
+    w.mu.Unlock()
+    <-w.done
+    if n := w.dropped.Load(); n > 0 {
+        log.Printf("dropped %d access log lines", n)
+    }
+    return nil
+}
+
+// logRequests reports every request to the access log and metrics, either
+// of which may be nil.
+func logRequests(next http.Handler, logf accessLogger, m *metrics) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        rw := &responseWriter{ResponseWriter: w}
+        if m != nil {
+            m.inFlight.Inc()
+        }
+        defer func() {
+            status, d := rw.statusCode(), time.Since(start)
+            if m != nil {
+                m.inFlight.Dec()
+                m.observe(r, status, rw.size, d)
+            }
+            if logf != nil {
+                logf(r, status, rw.size, d)
+            }
+        }()
+        next.ServeHTTP(rw, r)
+    })
+}
+
+// responseWriter records the status code and body size of a response. It
+// passes flushing, hijacking and sendfile through to the underlying writer.
+type responseWriter struct {
+    http.ResponseWriter
+    status int
+    size   int64
+}
+
+func (w *responseWriter) statusCode() int {
+    if w.status == 0 {
+        return http.StatusOK
+    }
+    return w.status
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+    if w.status == 0 && status >= 200 {
+        w.status = status
+    }
+    w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+    if w.status == 0 {
+        w.status = http.StatusOK
+    }
+    n, err := w.ResponseWriter.Write(p)
+    w.size += int64(n)
+    return n, err
+}
+
+func (w *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+    if w.status == 0 {
+        w.status = http.StatusOK
+    }
+    var n int64
+    var err error
+    if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+        n, err = rf.ReadFrom(src)
+    } else {
+        n, err = io.Copy(w.ResponseWriter, src)
+    }
+    w.size += n
+    return n, err
+}
+
+func (w *responseWriter) Flush() {
+    if w.status == 0 {
+        w.status = http.StatusOK
+    }
+    http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    hj, ok := w.ResponseWriter.(http.Hijacker)
+    if !ok {
+        return nil, nil, errors.New("response does not support hijacking")
+    }
+    conn, rw, err := hj.Hijack()
+    if err == nil && w.status == 0 {
+        w.status = http.StatusSwitchingProtocols
+    }
+    return conn, rw, err
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
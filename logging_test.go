@@ -0,0 +1,137 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "gopkg.in/natefinch/lumberjack.v2"
+)
+
+func TestJSONLogFile(t *testing.T) {
+    name := filepath.Join(t.TempDir(), "access.log")
+    rotator := &lumberjack.Logger{Filename: name, MaxSize: 1}
+    sink := newAsyncWriter(rotator, 4096)
+    logf, err := newAccessLogger("json", sink)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    const requests = 200
+    var wg sync.WaitGroup
+    for range requests {
+        wg.Go(func() {
+            req := httptest.NewRequest("GET", "/app.wasm", nil)
+            logf(req, 200, 1234, time.Millisecond)
+        })
+    }
+    wg.Wait()
+    sink.Close()
+    rotator.Close()
+
+    f, err := os.Open(name)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    lines := 0
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        var entry struct {
+            Msg    string `json:"msg"`
+            Path   string `json:"path"`
+            Status int    `json:"status"`
+            Bytes  int64  `json:"bytes"`
+        }
+        if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+            t.Fatalf("line %d: %v: %s", lines+1, err, scanner.Bytes())
+        }
+        if entry.Msg != "request" || entry.Path != "/app.wasm" ||
+                entry.Status != 200 || entry.Bytes != 1234 {
+            t.Errorf("line %d: %+v", lines+1, entry)
+        }
+        lines++
+    }
+    if lines != requests {
+        t.Errorf("%d lines in %s, want %d", lines, name, requests)
+    }
+}
+
+func TestAsyncWriterAfterClose(t *testing.T) {
+    f, err := os.Create(filepath.Join(t.TempDir(), "log"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    w := newAsyncWriter(f, 1)
+    w.Close()
+    // A handler outliving the shutdown timeout may still log.
+    if _, err := w.Write([]byte("late\n")); err != nil {
+        t.Fatal(err)
+    }
+    if n := w.dropped.Load(); n != 1 {
+        t.Errorf("dropped %d writes, want 1", n)
+    }
+}
+
+func TestResponseWriter(t *testing.T) {
+    for _, tt := range []struct {
+        name    string
+        handler http.HandlerFunc
+        status  int
+        size    int64
+    }{
+        {"write", func(w http.ResponseWriter, r *http.Request) {
+            w.Write([]byte("hello"))
+        }, http.StatusOK, 5},
+        {"error", func(w http.ResponseWriter, r *http.Request) {
+            http.Error(w, "nope", http.StatusNotFound)
+        }, http.StatusNotFound, 5},
+        {"empty", func(w http.ResponseWriter, r *http.Request) {
+            w.WriteHeader(http.StatusNoContent)
+        }, http.StatusNoContent, 0},
+        {"nothing", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, 0},
+        {"copy", func(w http.ResponseWriter, r *http.Request) {
+            io.Copy(w, strings.NewReader(strings.Repeat("x", 10000)))
+        }, http.StatusOK, 10000},
+    } {
+        var status int
+        var size int64
+        h := logRequests(tt.handler, func(r *http.Request, s int, n int64, d time.Duration) {
+            status, size = s, n
+        }, nil)
+        get(h, "/")
+        if status != tt.status || size != tt.size {
+            t.Errorf("%s: logged %d with %d bytes, want %d with %d",
+                tt.name, status, size, tt.status, tt.size)
+        }
+    }
+}
+
+func TestTextAccessLog(t *testing.T) {
+    var out bytes.Buffer
+    logf, err := newAccessLogger("text", &out)
+    if err != nil {
+        t.Fatal(err)
+    }
+    req := httptest.NewRequest("GET", "/app.wasm?v=2", nil)
+    req.Header.Set("User-Agent", "test")
+    logf(req, http.StatusOK, 1234, time.Millisecond)
+    line := out.String()
+    for _, want := range []string{
+        "192.0.2.1 - - [", `"GET /app.wasm?v=2 HTTP/1.1" 200 1234 "" "test" 1ms -`,
+    } {
+        if !strings.Contains(line, want) {
+            t.Errorf("log line %q lacks %q", line, want)
+        }
+    }
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the request instrumentation exported on /metrics. Requests
+// are labelled by the mux pattern they matched rather than by URL so that
+// the number of series stays bounded.
+type metrics struct {
+    registry *prometheus.Registry
+    mux      *http.ServeMux
+
+    requests *prometheus.CounterVec
+    duration *prometheus.HistogramVec
+    inFlight prometheus.Gauge
+    bytes    prometheus.Counter
+}
+
+func newMetrics(mux *http.ServeMux) *metrics {
+    m := &metrics{
+        registry: prometheus.NewRegistry(),
+        mux:      mux,
+        requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+            Name: "thierd_http_requests_total",
+            Help: "HTTP requests by status code and route.",
+        }, []string{"code", "route"}),
+        duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+            Name:    "thierd_http_request_duration_seconds",
+            Help:    "HTTP request latency by route.",
+            Buckets: prometheus.DefBuckets,
+        }, []string{"route"}),
+        inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+            Name: "thierd_http_requests_in_flight",
+            Help: "HTTP requests currently being served.",
+        }),
+        bytes: prometheus.NewCounter(prometheus.CounterOpts{
+            Name: "thierd_http_response_bytes_total",
+            Help: "Response body bytes written.",
+        }),
+    }
+    m.registry.MustRegister(
+        m.requests, m.duration, m.inFlight, m.bytes,
+        collectors.NewGoCollector(),
+        collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+    )
+    return m
+}
+
+func (m *metrics) handler() http.Handler {
+    return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *metrics) route(r *http.Request) string {
+    _, pattern := m.mux.Handler(r)
+    if pattern == "" {
+        return "unmatched"
+    }
+    return pattern
+}
+
+func (m *metrics) observe(r *http.Request, status int, size int64, d time.Duration) {
+    route := m.route(r)
+    m.requests.WithLabelValues(strconv.Itoa(status), route).Inc()
+    m.duration.WithLabelValues(route).Observe(d.Seconds())
+    m.bytes.Add(float64(size))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestMetrics(t *testing.T) {
+    mux := http.NewServeMux()
+    probes := &health{}
+    mux.HandleFunc("/healthz", probes.healthz)
+    stats := newMetrics(mux)
+    mux.Handle("/metrics", stats.handler())
+    h := logRequests(mux, nil, stats)
+
+    for range 3 {
+        get(h, "/healthz")
+    }
+    get(h, "/nowhere")
+    body := get(h, "/metrics").Body.String()
+    for _, want := range []string{
+        `thierd_http_requests_total{code="200",route="/healthz"} 3`,
+        `thierd_http_requests_total{code="404",route="unmatched"} 1`,
+        `thierd_http_request_duration_seconds_count{route="/healthz"} 3`,
+        // The scrape itself is still being served.
+        `thierd_http_requests_in_flight 1`,
+    } {
+        if !strings.Contains(body, want+"\n") {
+            t.Errorf("/metrics lacks %s", want)
+        }
+    }
+
+    get(h, "/healthz")
+    stats.observe(httptest.NewRequest("GET", "/healthz", nil), http.StatusOK, 0, time.Millisecond)
+    body = get(h, "/metrics").Body.String()
+    if !strings.Contains(body, `thierd_http_requests_total{code="200",route="/healthz"} 5`+"\n") {
+        t.Error("counter did not go up")
+    }
+}
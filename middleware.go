@@ -0,0 +1,248 @@
+package main
+
+import (
+    "crypto/sha256"
+    "crypto/subtle"
+    "fmt"
+    "io"
+    "log"
+    "mime"
+    "net/http"
+    "runtime/debug"
+    "slices"
+    "strings"
+
+    "golang.org/x/crypto/bcrypt"
+    "golang.org/x/net/http/httpguts"
+)
+
+// crossOriginIsolate sets the headers browsers require before exposing
+// SharedArrayBuffer to the page.
+func crossOriginIsolate(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Cross-Origin-Opener-Policy", "same-origin")
+        w.Header().Set("Cross-Origin-Embedder-Policy", "require-corp")
+        next.ServeHTTP(w, r)
+    })
+}
+
+// recoverPanics turns a panicking handler into a 500 response and a logged
+// stack trace, instead of a connection that just drops. A response that was
+// already under way cannot be turned into an error any more, so it is cut
+// off, which at least keeps the client from taking a truncated body for a
+// complete one.
+func recoverPanics(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        rw := &responseWriter{ResponseWriter: w}
+        defer func() {
+            err := recover()
+            if err == nil {
+                return
+            }
+            if err == http.ErrAbortHandler {
+                panic(err)
+            }
+            log.Printf("panic serving %s %s [%s]: %v\n%s",
+                r.Method, r.URL.Path, requestID(r.Context()), err, debug.Stack())
+            if rw.status != 0 {
+                panic(http.ErrAbortHandler)
+            }
+            h := w.Header()
+            for k := range h {
+                if k != "X-Request-Id" {
+                    delete(h, k)
+                }
+            }
+            http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+        }()
+        next.ServeHTTP(rw, r)
+    })
+}
+
+// contentSecurityPolicy sends policy with every HTML response, in
+// report-only mode if requested.
+func contentSecurityPolicy(next http.Handler, policy string, reportOnly bool) http.Handler {
+    header := "Content-Security-Policy"
+    if reportOnly {
+        header = "Content-Security-Policy-Report-Only"
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        hw := &headerHookWriter{ResponseWriter: w, hook: func(h http.Header) {
+            mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+            if mediaType == "text/html" {
+                h.Set(header, policy)
+            }
+        }}
+        next.ServeHTTP(hw, r)
+    })
+}
+
+// parseHeaders parses "Name: Value" header lines, rejecting names and values
+// that could not be sent as they are.
+func parseHeaders(lines []string) (http.Header, error) {
+    h := http.Header{}
+    for _, line := range lines {
+        name, value, ok := strings.Cut(line, ":")
+        value = strings.TrimSpace(value)
+        if !ok || !httpguts.ValidHeaderFieldName(name) {
+            return nil, fmt.Errorf("%q is not a \"Name: Value\" header", line)
+        }
+        if !httpguts.ValidHeaderFieldValue(value) {
+            return nil, fmt.Errorf("invalid value for header %s", name)
+        }
+        h.Add(name, value)
+    }
+    return h, nil
+}
+
+// addHeaders sends the extra headers with every response. They are applied
+// just before the response is written, so that they take precedence over
+// any the handlers set under the same name but leave all others alone.
+func addHeaders(next http.Handler, extra http.Header) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        hw := &headerHookWriter{ResponseWriter: w, hook: func(h http.Header) {
+            for name, values := range extra {
+                h[name] = slices.Clone(values)
+            }
+        }}
+        next.ServeHTTP(hw, r)
+    })
+}
+
+// headerHookWriter calls hook on the response headers just before they are
+// written, once the handler has settled on a content type.
+type headerHookWriter struct {
+    http.ResponseWriter
+    hook func(http.Header)
+    done bool
+}
+
+func (w *headerHookWriter) runHook() {
+    if !w.done {
+        w.done = true
+        w.hook(w.Header())
+    }
+}
+
+func (w *headerHookWriter) WriteHeader(status int) {
+    w.runHook()
+    w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *headerHookWriter) Write(p []byte) (int, error) {
+    if !w.done {
+        if w.Header().Get("Content-Type") == "" {
+            w.Header().Set("Content-Type", http.DetectContentType(p))
+        }
+        w.runHook()
+    }
+    return w.ResponseWriter.Write(p)
+}
+
+func (w *headerHookWriter) ReadFrom(src io.Reader) (int64, error) {
+    w.runHook()
+    if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+        return rf.ReadFrom(src)
+    }
+    return io.Copy(w.ResponseWriter, src)
+}
+
+func (w *headerHookWriter) Flush() {
+    w.runHook()
+    http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *headerHookWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+// cors allows the listed origins to fetch resources cross-origin. An origin
+// of "*" allows any origin but, as the spec requires, never credentials.
+// OPTIONS requests from allowed origins are answered here; preflights from
+// other origins get an empty 204 the browser will treat as a refusal.
+func cors(next http.Handler, origins []string, credentials bool) http.Handler {
+    allowed := map[string]bool{}
+    for _, o := range origins {
+        allowed[o] = true
+    }
+    wildcard := allowed["*"]
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        origin := r.Header.Get("Origin")
+        h := w.Header()
+        if !wildcard {
+            addVary(h, "Origin")
+        }
+        if origin == "" || !(wildcard || allowed[origin]) {
+            // Refuse preflights outright rather than letting them reach
+            // the file server, which would answer OPTIONS with a body.
+            if r.Method == http.MethodOptions &&
+                    r.Header.Get("Access-Control-Request-Method") != "" {
+                w.WriteHeader(http.StatusNoContent)
+                return
+            }
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        if wildcard {
+            h.Set("Access-Control-Allow-Origin", "*")
+        } else {
+            h.Set("Access-Control-Allow-Origin", origin)
+            if credentials {
+                h.Set("Access-Control-Allow-Credentials", "true")
+            }
+        }
+        if r.Method != http.MethodOptions {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        h.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, OPTIONS")
+        if req := r.Header.Get("Access-Control-Request-Headers"); req != "" {
+            h.Set("Access-Control-Allow-Headers", req)
+            addVary(h, "Access-Control-Request-Headers")
+        }
+        h.Set("Access-Control-Max-Age", "600")
+        w.WriteHeader(http.StatusNoContent)
+    })
+}
+
+// basicAuth requires HTTP Basic credentials accepted by check on every
+// request except those for the exempt paths.
+func basicAuth(next http.Handler, check func(user, pass string) bool, exempt ...string) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if slices.Contains(exempt, r.URL.Path) {
+            next.ServeHTTP(w, r)
+            return
+        }
+        user, pass, ok := r.BasicAuth()
+        if !ok || !check(user, pass) {
+            w.Header().Set("WWW-Authenticate", `Basic realm="thierd", charset="UTF-8"`)
+            http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// passwordChecker accepts wantUser with either the plain wantPass or a
+// password matching the bcrypt hash.
+func passwordChecker(wantUser, wantPass, hash string) func(user, pass string) bool {
+    return func(user, pass string) bool {
+        userOK := secureEqual(user, wantUser)
+        var passOK bool
+        if hash != "" {
+            passOK = bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+        } else {
+            passOK = secureEqual(pass, wantPass)
+        }
+        return userOK && passOK
+    }
+}
+
+// secureEqual compares digests so that neither the contents nor the
+// length of the secret leak through timing.
+func secureEqual(a, b string) bool {
+    ha, hb := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+    return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
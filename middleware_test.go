@@ -0,0 +1,256 @@
+package main
+
+import (
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "golang.org/x/crypto/bcrypt"
+)
+
+// hello answers every request with a short HTML page.
+var hello = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Write([]byte("<p>hello</p>"))
+})
+
+func TestCrossOriginIsolate(t *testing.T) {
+    isolated := []string{"Cross-Origin-Opener-Policy", "Cross-Origin-Embedder-Policy"}
+
+    rec := get(crossOriginIsolate(hello), "/")
+    want := map[string]string{
+        "Cross-Origin-Opener-Policy":   "same-origin",
+        "Cross-Origin-Embedder-Policy": "require-corp",
+    }
+    for _, name := range isolated {
+        if got := rec.Header().Get(name); got != want[name] {
+            t.Errorf("%s: %q, want %q", name, got, want[name])
+        }
+    }
+
+    // -coi=false leaves the handler unwrapped.
+    rec = get(hello, "/")
+    for _, name := range isolated {
+        if got := rec.Header().Get(name); got != "" {
+            t.Errorf("%s sent without -coi: %q", name, got)
+        }
+    }
+}
+
+func TestCORS(t *testing.T) {
+    h := cors(hello, []string{"https://game.example"}, true)
+
+    rec := get(h, "/", "Origin", "https://game.example")
+    if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://game.example" {
+        t.Errorf("allowed origin: Access-Control-Allow-Origin %q", got)
+    }
+    if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+        t.Errorf("allowed origin: Access-Control-Allow-Credentials %q", got)
+    }
+    if got := rec.Header().Get("Vary"); got != "Origin" {
+        t.Errorf("allowed origin: Vary %q", got)
+    }
+
+    rec = get(h, "/", "Origin", "https://evil.example")
+    if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" || rec.Code != http.StatusOK {
+        t.Errorf("disallowed origin: %d with Access-Control-Allow-Origin %q", rec.Code, got)
+    }
+
+    req := httptest.NewRequest("OPTIONS", "/rooms", nil)
+    req.Header.Set("Origin", "https://game.example")
+    req.Header.Set("Access-Control-Request-Method", "POST")
+    req.Header.Set("Access-Control-Request-Headers", "content-type")
+    rec = httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+        t.Errorf("preflight: %d %q, want 204", rec.Code, rec.Body)
+    }
+    for name, want := range map[string]string{
+        "Access-Control-Allow-Origin":  "https://game.example",
+        "Access-Control-Allow-Methods": "GET, HEAD, POST, OPTIONS",
+        "Access-Control-Allow-Headers": "content-type",
+        "Access-Control-Max-Age":       "600",
+    } {
+        if got := rec.Header().Get(name); got != want {
+            t.Errorf("preflight: %s %q, want %q", name, got, want)
+        }
+    }
+
+    req = httptest.NewRequest("OPTIONS", "/rooms", nil)
+    req.Header.Set("Origin", "https://evil.example")
+    req.Header.Set("Access-Control-Request-Method", "POST")
+    rec = httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+        t.Errorf("disallowed preflight: %d %q, want 204", rec.Code, rec.Body)
+    }
+    for name := range rec.Header() {
+        if strings.HasPrefix(name, "Access-Control-") {
+            t.Errorf("disallowed preflight: unexpected %s header", name)
+        }
+    }
+
+    req = httptest.NewRequest("OPTIONS", "/", nil)
+    req.Header.Set("Origin", "https://game.example")
+    rec = httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+        t.Errorf("OPTIONS without request method: %d %q, want 204", rec.Code, rec.Body)
+    }
+
+    rec = get(cors(hello, []string{"*"}, true), "/", "Origin", "https://any.example")
+    if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+        t.Errorf("wildcard: Access-Control-Allow-Origin %q", got)
+    }
+    if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+        t.Errorf("wildcard: Access-Control-Allow-Credentials %q", got)
+    }
+}
+
+func TestBasicAuth(t *testing.T) {
+    hash, err := bcrypt.GenerateFromPassword([]byte("hashed"), bcrypt.MinCost)
+    if err != nil {
+        t.Fatal(err)
+    }
+    plain := basicAuth(hello, passwordChecker("admin", "secret", ""), "/healthz")
+    hashed := basicAuth(hello, passwordChecker("admin", "", string(hash)))
+
+    for _, tt := range []struct {
+        name       string
+        h          http.Handler
+        path       string
+        user, pass string
+        send       bool
+        want       int
+    }{
+        {"missing", plain, "/", "", "", false, http.StatusUnauthorized},
+        {"wrong password", plain, "/", "admin", "guess", true, http.StatusUnauthorized},
+        {"wrong user", plain, "/", "root", "secret", true, http.StatusUnauthorized},
+        {"correct", plain, "/", "admin", "secret", true, http.StatusOK},
+        {"exempt", plain, "/healthz", "", "", false, http.StatusOK},
+        {"hash correct", hashed, "/", "admin", "hashed", true, http.StatusOK},
+        {"hash wrong", hashed, "/", "admin", "", true, http.StatusUnauthorized},
+    } {
+        req := httptest.NewRequest("GET", tt.path, nil)
+        if tt.send {
+            req.SetBasicAuth(tt.user, tt.pass)
+        }
+        rec := httptest.NewRecorder()
+        tt.h.ServeHTTP(rec, req)
+        if rec.Code != tt.want {
+            t.Errorf("%s: %d, want %d", tt.name, rec.Code, tt.want)
+        }
+        challenge := rec.Header().Get("WWW-Authenticate")
+        if (rec.Code == http.StatusUnauthorized) != (challenge != "") {
+            t.Errorf("%s: %d with WWW-Authenticate %q", tt.name, rec.Code, challenge)
+        }
+    }
+}
+
+func TestContentSecurityPolicy(t *testing.T) {
+    const policy = "default-src 'self'; script-src 'self' 'wasm-unsafe-eval'"
+    script := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/javascript")
+        w.Write([]byte("0"))
+    })
+    sniffed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("<!DOCTYPE html><p>hi</p>"))
+    })
+
+    for _, tt := range []struct {
+        name       string
+        h          http.Handler
+        reportOnly bool
+        header     string
+        want       string
+    }{
+        {"html", hello, false, "Content-Security-Policy", policy},
+        {"sniffed html", sniffed, false, "Content-Security-Policy", policy},
+        {"report only", hello, true, "Content-Security-Policy-Report-Only", policy},
+        {"script", script, false, "Content-Security-Policy", ""},
+    } {
+        rec := get(contentSecurityPolicy(tt.h, policy, tt.reportOnly), "/")
+        if got := rec.Header().Get(tt.header); got != tt.want {
+            t.Errorf("%s: %s %q, want %q", tt.name, tt.header, got, tt.want)
+        }
+    }
+}
+
+func TestRecoverPanics(t *testing.T) {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/wasm")
+        panic("boom")
+    })
+    mux.HandleFunc("/late", func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("partial"))
+        panic("boom")
+    })
+    mux.Handle("/ok", hello)
+    srv := httptest.NewServer(withRequestID(recoverPanics(mux)))
+    defer srv.Close()
+
+    resp, err := http.Get(srv.URL + "/panic")
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusInternalServerError ||
+            strings.HasPrefix(resp.Header.Get("Content-Type"), "application/wasm") ||
+            resp.Header.Get("X-Request-ID") == "" {
+        t.Errorf("panic: %d with headers %v, want 500 keeping only the request ID",
+            resp.StatusCode, resp.Header)
+    }
+
+    // Once the response has started, the connection is cut instead.
+    if resp, err := http.Get(srv.URL + "/late"); err == nil {
+        _, err = io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if err == nil {
+            t.Error("late panic: response completed normally")
+        }
+    }
+
+    resp, err = http.Get(srv.URL + "/ok")
+    if err != nil {
+        t.Fatalf("server down after panics: %v", err)
+    }
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK || string(body) != "<p>hello</p>" {
+        t.Errorf("after panics: %d %q", resp.StatusCode, body)
+    }
+}
+
+func TestAddHeaders(t *testing.T) {
+    extra, err := parseHeaders([]string{
+        "X-Frame-Options: DENY",
+        "Cross-Origin-Resource-Policy:same-site",
+        "content-type: text/x-override",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    rec := get(addHeaders(hello, extra), "/")
+    for name, want := range map[string]string{
+        "X-Frame-Options":              "DENY",
+        "Cross-Origin-Resource-Policy": "same-site",
+        // The configured header wins over the handler's.
+        "Content-Type": "text/x-override",
+    } {
+        if got := rec.Header().Values(name); len(got) != 1 || got[0] != want {
+            t.Errorf("%s: %q, want %q", name, got, want)
+        }
+    }
+    if rec.Body.String() != "<p>hello</p>" {
+        t.Errorf("body %q", rec.Body)
+    }
+
+    for _, bad := range []string{"X-Frame-Options DENY", "Bad Name: x", "X-Bad: a\nb"} {
+        if _, err := parseHeaders([]string{bad}); err == nil {
+            t.Errorf("parseHeaders accepted %q", bad)
+        }
+    }
+}
@@ -0,0 +1,9 @@
+//go:build !embed
+
+package main
+
+import "io/fs"
+
+func embeddedFiles() (fs.FS, error) {
+    return nil, nil
+}
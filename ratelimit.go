@@ -0,0 +1,117 @@
+package main
+
+import (
+    "math"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/gorilla/websocket"
+    "golang.org/x/time/rate"
+)
+
+// rateLimiter keeps a token bucket per client IP.
+type rateLimiter struct {
+    limit rate.Limit
+    burst int
+
+    mu      sync.Mutex
+    buckets map[string]*bucket
+}
+
+type bucket struct {
+    limiter *rate.Limiter
+    seen    time.Time
+}
+
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+    return &rateLimiter{
+        limit:   rate.Limit(perSecond),
+        burst:   max(burst, 1),
+        buckets: map[string]*bucket{},
+    }
+}
+
+// allow takes a token from ip's bucket, or reports how long until one is
+// available.
+func (rl *rateLimiter) allow(ip string) (bool, time.Duration) {
+    now := time.Now()
+    rl.mu.Lock()
+    b := rl.buckets[ip]
+    if b == nil {
+        b = &bucket{limiter: rate.NewLimiter(rl.limit, rl.burst)}
+        rl.buckets[ip] = b
+    }
+    b.seen = now
+    rl.mu.Unlock()
+
+    res := b.limiter.ReserveN(now, 1)
+    if delay := res.DelayFrom(now); delay > 0 {
+        res.CancelAt(now)
+        return false, delay
+    }
+    return true, 0
+}
+
+// refill reports how long an empty bucket takes to fill back up to burst.
+func (rl *rateLimiter) refill() time.Duration {
+    return time.Duration(float64(rl.burst) / float64(rl.limit) * float64(time.Second))
+}
+
+// evict periodically drops buckets that have not been used for idle. A
+// dropped bucket comes back full, so idle must be at least refill or an
+// idle client would get its burst back early.
+func (rl *rateLimiter) evict(idle time.Duration) {
+    for now := range time.Tick(idle) {
+        rl.mu.Lock()
+        for ip, b := range rl.buckets {
+            if now.Sub(b.seen) > idle {
+                delete(rl.buckets, ip)
+            }
+        }
+        rl.mu.Unlock()
+    }
+}
+
+// rateLimit rejects requests beyond the per-IP limits with 429 Too Many
+// Requests. WebSocket and WebTransport sessions are counted against sockets
+// and everything else against assets; either may be nil for no limit.
+func rateLimit(next http.Handler, assets, sockets *rateLimiter, trustProxy bool) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        rl := assets
+        if websocket.IsWebSocketUpgrade(r) || isWebTransport(r) {
+            rl = sockets
+        }
+        if rl != nil {
+            if ok, wait := rl.allow(clientIP(r, trustProxy)); !ok {
+                secs := int(math.Ceil(wait.Seconds()))
+                w.Header().Set("Retry-After", strconv.Itoa(secs))
+                http.Error(w, "429 Too Many Requests", http.StatusTooManyRequests)
+                return
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// clientIP returns the address a request came from. Behind a trusted proxy
+// that is the last X-Forwarded-For entry, the one the proxy itself added;
+// earlier entries are supplied by the client and cannot be trusted.
+func clientIP(r *http.Request, trustProxy bool) string {
+    if trustProxy {
+        if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+            hops := strings.Split(xff[len(xff)-1], ",")
+            if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+                return ip
+            }
+        }
+    }
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
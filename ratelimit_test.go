@@ -0,0 +1,63 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestRateLimit(t *testing.T) {
+    const burst = 5
+    h := rateLimit(hello, newRateLimiter(0.001, burst), newRateLimiter(0.001, 1), false)
+    send := func(remote string, upgrade bool) *httptest.ResponseRecorder {
+        req := httptest.NewRequest("GET", "/", nil)
+        req.RemoteAddr = remote
+        if upgrade {
+            req.Header.Set("Connection", "Upgrade")
+            req.Header.Set("Upgrade", "websocket")
+        }
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+        return rec
+    }
+
+    for i := range burst {
+        if rec := send("192.0.2.1:1000", false); rec.Code != http.StatusOK {
+            t.Fatalf("request %d: %d", i+1, rec.Code)
+        }
+    }
+    rec := send("192.0.2.1:1001", false)
+    if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+        t.Errorf("request %d: %d with Retry-After %q, want 429",
+            burst+1, rec.Code, rec.Header().Get("Retry-After"))
+    }
+    if rec := send("192.0.2.2:1000", false); rec.Code != http.StatusOK {
+        t.Errorf("another client: %d", rec.Code)
+    }
+
+    // Sockets have their own bucket.
+    if rec := send("192.0.2.1:1002", true); rec.Code != http.StatusOK {
+        t.Errorf("first upgrade: %d", rec.Code)
+    }
+    if rec := send("192.0.2.1:1003", true); rec.Code != http.StatusTooManyRequests {
+        t.Errorf("second upgrade: %d, want 429", rec.Code)
+    }
+    req := httptest.NewRequest("CONNECT", "/wt", nil)
+    req.Proto = "webtransport"
+    req.RemoteAddr = "192.0.2.1:1004"
+    rec = httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    if rec.Code != http.StatusTooManyRequests {
+        t.Errorf("WebTransport session: %d, want 429 from the socket bucket", rec.Code)
+    }
+}
+
+func TestRateLimitRefill(t *testing.T) {
+    if got := newRateLimiter(0.5, 10).refill(); got != 20*time.Second {
+        t.Errorf("refill at 0.5/s with burst 10: %v, want 20s", got)
+    }
+    if got := newRateLimiter(0.001, 1).refill(); got != 1000*time.Second {
+        t.Errorf("refill at 0.001/s with burst 1: %v, want 1000s", got)
+    }
+}
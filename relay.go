@@ -0,0 +1,639 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+const (
+    maxMessageSize = 64 << 10
+    sendQueueSize  = 64
+    maxReplay      = 1024
+    writeWait      = 10 * time.Second
+    seqHeaderSize  = 8
+)
+
+// relay forwards WebSocket messages between the players in a room without
+// interpreting them. Each player holds a numbered slot in its room and is
+// given a reconnect token so that it can take the slot back after a
+// dropped connection, as long as it returns within the grace period.
+//
+// Rooms either broadcast every message to everyone, or are hosted: the
+// first player to join becomes the host, whose messages go to all the
+// others while theirs go only to the host.
+type relay struct {
+    maxPlayers int
+    grace      time.Duration
+    // seq prefixes every relayed message with its sequence number in the
+    // room, so that clients agree on an order and can spot gaps.
+    seq bool
+    // logFrames logs every message clients send, not just a summary when
+    // they leave.
+    logFrames bool
+    upgrader  websocket.Upgrader
+
+    mu       sync.Mutex
+    rooms    map[string]*room
+    sessions map[string]*player
+    // draining is set once shutdown begins, after which no connections
+    // are accepted.
+    draining bool
+    // active counts attached connections.
+    active sync.WaitGroup
+}
+
+type room struct {
+    name    string
+    created time.Time
+    roomOptions
+    seq bool
+
+    mu      sync.Mutex
+    players []*player
+    // host is the player peers' messages are routed to in a hosted room.
+    host    *player
+    history []message
+    // sent counts the reliable messages broadcast in the room, so that a
+    // returning player can tell which of the history it missed.
+    sent uint64
+    // lastSeq is the sequence number last stamped on a message. Hosted
+    // rooms number their peers' messages separately in lastPeerSeq, as the
+    // host receives only those and the peers only the rest.
+    lastSeq, lastPeerSeq uint64
+}
+
+type message struct {
+    n    uint64
+    from *player
+    data []byte
+}
+
+type player struct {
+    room  *room
+    index int
+    token string
+    // client is nil while the player is disconnected.
+    client *client
+    // seen is the number of messages the room had sent when the player
+    // disconnected.
+    seen   uint64
+    expire *time.Timer
+}
+
+// client is one connection to the relay, over either a WebSocket or
+// WebTransport.
+type client struct {
+    addr net.Addr
+    // id is the request ID of the upgrade request, for the logs.
+    id   string
+    send chan []byte
+    // datagram sends an unreliable message, and is nil for transports that
+    // only deliver reliably.
+    datagram func([]byte) error
+    // notify sends a JSON event from the relay itself, out of band from the
+    // players' messages. It must not block.
+    notify func([]byte)
+    // goAway asks the client to disconnect because the server is shutting
+    // down. It must not block.
+    goAway func()
+    // abort cuts the connection off, passing on a WebSocket close code and
+    // reason where the transport can still deliver them. It must not block.
+    abort func(code int, reason string)
+    // frames counts the messages received from the client, each of which
+    // is logged if logFrames is set.
+    frames    atomic.Int64
+    logFrames bool
+}
+
+// welcome is the first frame sent on every connection.
+type welcome struct {
+    ReconnectToken string `json:"reconnectToken"`
+    Player         int    `json:"player"`
+    // Host is the host's slot in a hosted room.
+    Host *int `json:"host,omitempty"`
+}
+
+// hostEvent tells the players in a hosted room that the host has changed.
+type hostEvent struct {
+    Event  string `json:"event"`
+    Player int    `json:"player"`
+}
+
+// Policies for a hosted room whose host leaves.
+const (
+    hostLeavePromote = "promote"
+    hostLeaveClose   = "close"
+)
+
+// roomOptions are chosen by whoever creates a room.
+type roomOptions struct {
+    // replay is how many recent messages are kept to resend to players
+    // that reconnect, zero when the room did not opt in.
+    replay int
+    hosted bool
+    // hostLeave says whether a hosted room promotes another player or
+    // closes when its host's slot is released.
+    hostLeave string
+}
+
+// parseRoomOptions reads room options from the replay, mode and hostLeave
+// query parameters.
+func parseRoomOptions(q url.Values) (roomOptions, error) {
+    replay, _ := strconv.Atoi(q.Get("replay"))
+    opts := roomOptions{replay: min(max(replay, 0), maxReplay)}
+    switch q.Get("mode") {
+    case "", "broadcast":
+    case "host":
+        opts.hosted = true
+    default:
+        return opts, fmt.Errorf("unknown room mode %q", q.Get("mode"))
+    }
+    opts.hostLeave = q.Get("hostLeave")
+    switch opts.hostLeave {
+    case "":
+        opts.hostLeave = hostLeavePromote
+    case hostLeavePromote, hostLeaveClose:
+    default:
+        return opts, fmt.Errorf("unknown hostLeave policy %q", opts.hostLeave)
+    }
+    return opts, nil
+}
+
+func newRelay(maxPlayers int, grace time.Duration) *relay {
+    return &relay{
+        maxPlayers: maxPlayers,
+        grace:      grace,
+        rooms:      map[string]*room{},
+        sessions:   map[string]*player{},
+    }
+}
+
+func (rl *relay) newRoom(name string, opts roomOptions) *room {
+    return &room{
+        name:        name,
+        created:     time.Now(),
+        roomOptions: opts,
+        seq:         rl.seq,
+        players:     make([]*player, rl.maxPlayers),
+    }
+}
+
+func (rl *relay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    if q.Get("room") == "" && q.Get("token") == "" {
+        http.Error(w, "missing room or token", http.StatusBadRequest)
+        return
+    }
+    if rl.isDraining() {
+        http.Error(w, shutdownReason, http.StatusServiceUnavailable)
+        return
+    }
+    conn, err := upgrade(&rl.upgrader, w, r)
+    if err != nil {
+        return
+    }
+    conn.SetReadLimit(maxMessageSize)
+
+    events := make(chan []byte, sendQueueSize)
+    c := &client{
+        addr:      conn.RemoteAddr(),
+        id:        requestID(r.Context()),
+        abort:     func(int, string) { conn.Close() },
+        logFrames: rl.logFrames,
+    }
+    c.goAway = func() {
+        msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, shutdownReason)
+        conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+    }
+    c.notify = func(event []byte) {
+        select {
+        case events <- event:
+        default:
+            c.abort(websocket.CloseTryAgainLater, "too slow")
+        }
+    }
+    p, code, reason := rl.attach(q, c)
+    if p == nil {
+        closeWith(conn, code, reason)
+        return
+    }
+    defer rl.leave(p, c)
+
+    conn.SetWriteDeadline(time.Now().Add(writeWait))
+    if err := conn.WriteJSON(p.room.welcome(p)); err != nil {
+        return
+    }
+    go writeLoop(conn, c.send, events)
+
+    for {
+        _, msg, err := conn.ReadMessage()
+        if err != nil {
+            return
+        }
+        c.received("message", msg)
+        p.room.broadcast(p, msg)
+    }
+}
+
+// attach seats c in the room named by the query, or back in its old slot
+// when the query carries a reconnect token. On failure it returns a nil
+// player along with a WebSocket close code and reason.
+func (rl *relay) attach(q url.Values, c *client) (*player, int, string) {
+    if rl.isDraining() {
+        return nil, websocket.CloseGoingAway, shutdownReason
+    }
+    if token := q.Get("token"); token != "" {
+        if p := rl.resume(token, c); p != nil {
+            c.logf("rejoined room %q slot %d", p.room.name, p.index)
+            return p, 0, ""
+        }
+        c.logf("refused: invalid or expired token")
+        return nil, websocket.ClosePolicyViolation, "invalid or expired token"
+    }
+    opts, err := parseRoomOptions(q)
+    if err != nil {
+        c.logf("refused: %v", err)
+        return nil, websocket.ClosePolicyViolation, err.Error()
+    }
+    if p := rl.join(q.Get("room"), opts, c); p != nil {
+        c.logf("joined room %q slot %d", p.room.name, p.index)
+        return p, 0, ""
+    }
+    c.logf("refused: room %q is full", q.Get("room"))
+    return nil, websocket.CloseTryAgainLater, "room is full"
+}
+
+// join gives c a free slot in the named room, creating the room with opts
+// if needed. It returns nil when the room is already full.
+func (rl *relay) join(name string, opts roomOptions, c *client) *player {
+    rl.mu.Lock()
+    defer rl.mu.Unlock()
+    if rl.draining {
+        return nil
+    }
+    rm := rl.rooms[name]
+    if rm == nil {
+        rm = rl.newRoom(name, opts)
+        rl.rooms[name] = rm
+    }
+    rm.mu.Lock()
+    defer rm.mu.Unlock()
+    for i, slot := range rm.players {
+        if slot != nil {
+            continue
+        }
+        p := &player{room: rm, index: i, token: rand.Text(), client: c}
+        c.send = make(chan []byte, sendQueueSize)
+        rm.players[i] = p
+        rl.sessions[p.token] = p
+        rl.active.Add(1)
+        if rm.hosted && rm.host == nil {
+            rm.host = p
+        }
+        return p
+    }
+    return nil
+}
+
+// welcome describes p's place in its room.
+func (rm *room) welcome(p *player) welcome {
+    rm.mu.Lock()
+    defer rm.mu.Unlock()
+    w := welcome{ReconnectToken: p.token, Player: p.index}
+    if rm.host != nil {
+        w.Host = &rm.host.index
+    }
+    return w
+}
+
+// resume reattaches c to the disconnected player holding token, queueing
+// any messages it missed if the room keeps a replay buffer.
+func (rl *relay) resume(token string, c *client) *player {
+    rl.mu.Lock()
+    defer rl.mu.Unlock()
+    p := rl.sessions[token]
+    if p == nil || rl.draining {
+        return nil
+    }
+    rm := p.room
+    rm.mu.Lock()
+    defer rm.mu.Unlock()
+    if p.client != nil {
+        return nil
+    }
+    if p.expire != nil {
+        p.expire.Stop()
+        p.expire = nil
+    }
+    c.send = make(chan []byte, sendQueueSize+len(rm.history))
+    for _, m := range rm.history {
+        if m.n > p.seen && rm.routes(m.from, p) {
+            c.send <- m.data
+        }
+    }
+    p.client = c
+    rl.active.Add(1)
+    return p
+}
+
+// leave detaches c from its player. The slot stays reserved for the grace
+// period so that the player can reconnect, and is freed after that.
+func (rl *relay) leave(p *player, c *client) {
+    c.logf("left room %q slot %d after %d frames",
+        p.room.name, p.index, c.frames.Load())
+    defer rl.active.Done()
+    rl.mu.Lock()
+    defer rl.mu.Unlock()
+    rm := p.room
+    rm.mu.Lock()
+    defer rm.mu.Unlock()
+    if p.client != c {
+        return
+    }
+    p.client = nil
+    p.seen = rm.sent
+    close(c.send)
+    if rl.grace <= 0 {
+        rl.free(p)
+        return
+    }
+    p.expire = time.AfterFunc(rl.grace, func() {
+        rl.mu.Lock()
+        defer rl.mu.Unlock()
+        rm.mu.Lock()
+        defer rm.mu.Unlock()
+        if p.client == nil && rm.players[p.index] == p {
+            rl.free(p)
+        }
+    })
+}
+
+// free releases the slot held by p and tears its room down once nobody
+// holds a slot in it. Both rl.mu and the room's lock must be held.
+func (rl *relay) free(p *player) {
+    rm := p.room
+    rm.players[p.index] = nil
+    delete(rl.sessions, p.token)
+    if rm.host == p {
+        rl.replaceHost(rm)
+    }
+    if rm.occupied() == 0 && rl.rooms[rm.name] == rm {
+        delete(rl.rooms, rm.name)
+    }
+}
+
+// replaceHost handles the host of a hosted room leaving, by either making
+// the connected player in the lowest slot the new host or sending everyone
+// away. Both rl.mu and the room's lock must be held.
+func (rl *relay) replaceHost(rm *room) {
+    rm.host = nil
+    if rm.hostLeave == hostLeaveClose {
+        for i, p := range rm.players {
+            if p == nil {
+                continue
+            }
+            rm.players[i] = nil
+            delete(rl.sessions, p.token)
+            if p.expire != nil {
+                p.expire.Stop()
+            }
+            if p.client != nil {
+                close(p.client.send)
+                p.client = nil
+            }
+        }
+        return
+    }
+
+    for _, p := range rm.players {
+        if p != nil && p.client != nil {
+            rm.host = p
+            break
+        }
+    }
+    if rm.host == nil {
+        return
+    }
+    event, _ := json.Marshal(hostEvent{Event: "host", Player: rm.host.index})
+    for _, p := range rm.players {
+        if p != nil && p.client != nil {
+            p.client.notify(event)
+        }
+    }
+}
+
+// occupied counts the slots held by players, connected or not. rm.mu must
+// be held.
+func (rm *room) occupied() int {
+    n := 0
+    for _, p := range rm.players {
+        if p != nil {
+            n++
+        }
+    }
+    return n
+}
+
+// connected counts the players with a live connection. rm.mu must be held.
+func (rm *room) connected() int {
+    n := 0
+    for _, p := range rm.players {
+        if p != nil && p.client != nil {
+            n++
+        }
+    }
+    return n
+}
+
+// stamp puts msg from a player behind an 8 byte little endian header
+// holding the next sequence number, if the room numbers its messages.
+// rm.mu must be held.
+func (rm *room) stamp(from *player, msg []byte) []byte {
+    if !rm.seq {
+        return msg
+    }
+    n := &rm.lastSeq
+    if rm.hosted && from != rm.host {
+        n = &rm.lastPeerSeq
+    }
+    *n++
+    frame := make([]byte, seqHeaderSize, seqHeaderSize+len(msg))
+    binary.LittleEndian.PutUint64(frame, *n)
+    return append(frame, msg...)
+}
+
+// broadcast queues msg for every connected player in the room except the
+// sender.
+func (rm *room) broadcast(from *player, msg []byte) {
+    rm.mu.Lock()
+    defer rm.mu.Unlock()
+    msg = rm.stamp(from, msg)
+    rm.sent++
+    if rm.replay > 0 {
+        if len(rm.history) == rm.replay {
+            rm.history = rm.history[1:]
+        }
+        rm.history = append(rm.history,
+            message{n: rm.sent, from: from, data: msg})
+    }
+    for _, p := range rm.players {
+        if p == nil || p.client == nil || !rm.routes(from, p) {
+            continue
+        }
+        p.client.queue(msg)
+    }
+}
+
+// routes reports whether a message from one player should reach another.
+// In a hosted room only messages to or from the host are passed on. rm.mu
+// must be held.
+func (rm *room) routes(from, to *player) bool {
+    if from == to {
+        return false
+    }
+    return !rm.hosted || from == rm.host || to == rm.host
+}
+
+// broadcastDatagram passes an unreliable message to every other connected
+// player, falling back to the reliable channel for clients without
+// datagram support. Datagrams are numbered along with the room's other
+// messages but are not kept for replay.
+func (rm *room) broadcastDatagram(from *player, msg []byte) {
+    rm.mu.Lock()
+    defer rm.mu.Unlock()
+    msg = rm.stamp(from, msg)
+    for _, p := range rm.players {
+        if p == nil || p.client == nil || !rm.routes(from, p) {
+            continue
+        }
+        if p.client.datagram != nil {
+            p.client.datagram(msg)
+        } else {
+            p.client.queue(msg)
+        }
+    }
+}
+
+// queue hands msg to the client's writer. Clients that fall too far behind
+// are disconnected rather than allowed to stall the room.
+func (c *client) queue(msg []byte) {
+    select {
+    case c.send <- msg:
+    default:
+        c.logf("dropping slow client")
+        c.abort(websocket.CloseTryAgainLater, "too slow")
+    }
+}
+
+// received counts a message from the client, logging it if the relay logs
+// frames.
+func (c *client) received(kind string, msg []byte) {
+    if n := c.frames.Add(1); c.logFrames {
+        c.logf("%s %d: %d bytes", kind, n, len(msg))
+    }
+}
+
+// logf logs a message about the connection, tagged with its address and
+// request ID.
+func (c *client) logf(format string, args ...any) {
+    log.Printf("relay: %s [%s] %s", c.addr, c.id, fmt.Sprintf(format, args...))
+}
+
+const shutdownReason = "server shutting down"
+
+func (rl *relay) isDraining() bool {
+    rl.mu.Lock()
+    defer rl.mu.Unlock()
+    return rl.draining
+}
+
+// drain stops new connections and asks every connected client to go away,
+// so that games can offer to reconnect rather than report an error. It
+// waits for the clients to disconnect until ctx is done, and then cuts off
+// any that remain.
+func (rl *relay) drain(ctx context.Context) error {
+    rl.mu.Lock()
+    rl.draining = true
+    var clients []*client
+    for _, rm := range rl.rooms {
+        rm.mu.Lock()
+        for _, p := range rm.players {
+            if p != nil && p.client != nil {
+                clients = append(clients, p.client)
+            }
+        }
+        rm.mu.Unlock()
+    }
+    rl.mu.Unlock()
+
+    for _, c := range clients {
+        c.goAway()
+    }
+    done := make(chan struct{})
+    go func() {
+        rl.active.Wait()
+        close(done)
+    }()
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        for _, c := range clients {
+            c.abort(websocket.CloseGoingAway, shutdownReason)
+        }
+        return ctx.Err()
+    }
+}
+
+// writeLoop writes messages from send as binary frames and relay events as
+// text frames, until send is closed.
+func writeLoop(conn *websocket.Conn, send, events <-chan []byte) {
+    defer conn.Close()
+    for {
+        kind, msg, ok := websocket.BinaryMessage, []byte(nil), true
+        select {
+        case msg, ok = <-send:
+        case msg = <-events:
+            kind = websocket.TextMessage
+        }
+        if !ok {
+            break
+        }
+        conn.SetWriteDeadline(time.Now().Add(writeWait))
+        if err := conn.WriteMessage(kind, msg); err != nil {
+            return
+        }
+    }
+    closeWith(conn, websocket.CloseNormalClosure, "")
+}
+
+// upgrade switches the request to a WebSocket and lifts the deadlines the
+// HTTP server's read and write timeouts placed on the connection, which
+// would otherwise drop long-lived sockets.
+func upgrade(u *websocket.Upgrader, w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+    conn, err := u.Upgrade(w, r, nil)
+    if err != nil {
+        return nil, err
+    }
+    conn.NetConn().SetDeadline(time.Time{})
+    return conn, nil
+}
+
+func closeWith(conn *websocket.Conn, code int, reason string) {
+    msg := websocket.FormatCloseMessage(code, reason)
+    conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+    conn.Close()
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/binary"
+    "errors"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/gorilla/websocket"
+)
+
+// startRelay serves rl at /ws on a test server and returns the server's
+// ws:// URL.
+func startRelay(t *testing.T, rl *relay) string {
+    t.Helper()
+    mux := http.NewServeMux()
+    mux.Handle("/ws", rl)
+    srv := httptest.NewServer(mux)
+    t.Cleanup(srv.Close)
+    return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// dialRoom connects to the relay with query and reads the welcome frame.
+func dialRoom(t *testing.T, url, query string) (*websocket.Conn, welcome) {
+    t.Helper()
+    conn, _, err := websocket.DefaultDialer.Dial(url+"/ws?"+query, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { conn.Close() })
+    var w welcome
+    conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    if err := conn.ReadJSON(&w); err != nil {
+        t.Fatalf("welcome: %v", err)
+    }
+    return conn, w
+}
+
+// readMessage returns the next message on conn, failing the test if none
+// arrives soon.
+func readMessage(t *testing.T, conn *websocket.Conn) []byte {
+    t.Helper()
+    conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    _, msg, err := conn.ReadMessage()
+    if err != nil {
+        t.Fatalf("read: %v", err)
+    }
+    return msg
+}
+
+// expectClose reads from conn until the server closes it with code.
+func expectClose(t *testing.T, conn *websocket.Conn, code int) {
+    t.Helper()
+    conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+    for {
+        _, msg, err := conn.ReadMessage()
+        if err == nil {
+            t.Logf("skipping message %q", msg)
+            continue
+        }
+        if !websocket.IsCloseError(err, code) {
+            t.Fatalf("got %v, want close code %d", err, code)
+        }
+        return
+    }
+}
+
+// expectSilence checks that nothing arrives on conn for a short while.
+func expectSilence(t *testing.T, conn *websocket.Conn) {
+    t.Helper()
+    conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+    if _, msg, err := conn.ReadMessage(); err == nil {
+        t.Errorf("unexpected message %q", msg)
+    }
+}
+
+// waitFor polls cond until it holds, failing the test if it never does.
+func waitFor(t *testing.T, what string, cond func() bool) {
+    t.Helper()
+    for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+        if time.Now().After(deadline) {
+            t.Fatalf("timed out waiting for %s", what)
+        }
+    }
+}
+
+func TestRelayBroadcast(t *testing.T) {
+    url := startRelay(t, newRelay(2, 0))
+    a, wa := dialRoom(t, url, "room=r")
+    b, wb := dialRoom(t, url, "room=r")
+    if wa.Player != 0 || wb.Player != 1 || wa.ReconnectToken == "" {
+        t.Errorf("welcomes %+v and %+v", wa, wb)
+    }
+
+    if err := a.WriteMessage(websocket.BinaryMessage, []byte("hello")); err != nil {
+        t.Fatal(err)
+    }
+    if msg := readMessage(t, b); string(msg) != "hello" {
+        t.Errorf("b got %q, want hello", msg)
+    }
+    expectSilence(t, a)
+
+    full, _, err := websocket.DefaultDialer.Dial(url+"/ws?room=r", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer full.Close()
+    expectClose(t, full, websocket.CloseTryAgainLater)
+
+    if _, resp, err := websocket.DefaultDialer.Dial(url+"/ws", nil); err == nil ||
+            resp == nil || resp.StatusCode != http.StatusBadRequest {
+        t.Errorf("dial without a room: %v, want 400", err)
+    }
+}
+
+// connectedIn counts the live connections in the named room.
+func connectedIn(rl *relay, name string) int {
+    rl.mu.Lock()
+    rm := rl.rooms[name]
+    rl.mu.Unlock()
+    if rm == nil {
+        return 0
+    }
+    rm.mu.Lock()
+    defer rm.mu.Unlock()
+    return rm.connected()
+}
+
+func TestResume(t *testing.T) {
+    rl := newRelay(4, 200*time.Millisecond)
+    url := startRelay(t, rl)
+    a, wa := dialRoom(t, url, "room=r&replay=8")
+    b, _ := dialRoom(t, url, "room=r")
+
+    a.Close()
+    waitFor(t, "a to drop", func() bool { return connectedIn(rl, "r") == 1 })
+    if err := b.WriteMessage(websocket.BinaryMessage, []byte("missed")); err != nil {
+        t.Fatal(err)
+    }
+    waitFor(t, "the message to be relayed", func() bool {
+        rl.mu.Lock()
+        defer rl.mu.Unlock()
+        rm := rl.rooms["r"]
+        rm.mu.Lock()
+        defer rm.mu.Unlock()
+        return rm.sent == 1
+    })
+
+    back, wb := dialRoom(t, url, "token="+wa.ReconnectToken)
+    if wb.Player != wa.Player || wb.ReconnectToken != wa.ReconnectToken {
+        t.Errorf("resumed as %+v, want %+v", wb, wa)
+    }
+    if msg := readMessage(t, back); string(msg) != "missed" {
+        t.Errorf("replayed %q, want missed", msg)
+    }
+
+    back.Close()
+    waitFor(t, "a to drop again", func() bool { return connectedIn(rl, "r") == 1 })
+    time.Sleep(300 * time.Millisecond)
+    late, _, err := websocket.DefaultDialer.Dial(url+"/ws?token="+wa.ReconnectToken, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer late.Close()
+    expectClose(t, late, websocket.ClosePolicyViolation)
+}
+
+func TestSequenceNumbers(t *testing.T) {
+    rl := newRelay(3, 0)
+    rl.seq = true
+    url := startRelay(t, rl)
+    a, _ := dialRoom(t, url, "room=r")
+    b, _ := dialRoom(t, url, "room=r")
+    c, _ := dialRoom(t, url, "room=r")
+
+    // Numbers count the room's messages, so they climb for every receiver
+    // even with two players sending in turn.
+    var last uint64
+    for i := range 6 {
+        from := []*websocket.Conn{a, c}[i%2]
+        if err := from.WriteMessage(websocket.BinaryMessage, []byte{byte(i)}); err != nil {
+            t.Fatal(err)
+        }
+        frame := readMessage(t, b)
+        if len(frame) != seqHeaderSize+1 || frame[seqHeaderSize] != byte(i) {
+            t.Fatalf("message %d: frame %x", i, frame)
+        }
+        n := binary.LittleEndian.Uint64(frame)
+        if n <= last {
+            t.Errorf("message %d: sequence number %d after %d", i, n, last)
+        }
+        last = n
+    }
+    for _, conn := range []*websocket.Conn{a, c} {
+        prev := uint64(0)
+        for range 3 {
+            n := binary.LittleEndian.Uint64(readMessage(t, conn))
+            if n <= prev {
+                t.Errorf("sequence number %d after %d", n, prev)
+            }
+            prev = n
+        }
+    }
+}
+
+func TestSequencedDatagrams(t *testing.T) {
+    rl := newRelay(3, 0)
+    rl.seq = true
+    from := rl.join("r", roomOptions{}, &client{})
+    var datagrams [][]byte
+    wt := &client{datagram: func(msg []byte) error {
+        datagrams = append(datagrams, msg)
+        return nil
+    }}
+    rl.join("r", roomOptions{}, wt)
+    ws := &client{}
+    rl.join("r", roomOptions{}, ws)
+
+    from.room.broadcast(from, []byte("a"))
+    from.room.broadcastDatagram(from, []byte("b"))
+    from.room.broadcast(from, []byte("c"))
+
+    // A client without datagrams gets the datagram on its reliable channel,
+    // numbered in line with everything else.
+    for i, want := range []string{"a", "b", "c"} {
+        frame := <-ws.send
+        if n := binary.LittleEndian.Uint64(frame); n != uint64(i+1) ||
+                string(frame[seqHeaderSize:]) != want {
+            t.Errorf("reliable-only message %d: %d %q, want %d %q",
+                i, n, frame[seqHeaderSize:], i+1, want)
+        }
+    }
+    if len(datagrams) != 1 || binary.LittleEndian.Uint64(datagrams[0]) != 2 {
+        t.Errorf("datagrams %x, want one numbered 2", datagrams)
+    }
+    for _, want := range []uint64{1, 3} {
+        if n := binary.LittleEndian.Uint64(<-wt.send); n != want {
+            t.Errorf("stream message numbered %d, want %d", n, want)
+        }
+    }
+}
+
+func TestHostedRoom(t *testing.T) {
+    rl := newRelay(3, 0)
+    url := startRelay(t, rl)
+    host, wh := dialRoom(t, url, "room=r&mode=host&hostLeave=close")
+    a, wa := dialRoom(t, url, "room=r")
+    b, _ := dialRoom(t, url, "room=r")
+    if wh.Host == nil || *wh.Host != wh.Player || wa.Host == nil || *wa.Host != wh.Player {
+        t.Fatalf("welcomes %+v and %+v do not name the host", wh, wa)
+    }
+
+    // The host's messages fan out to every peer.
+    if err := host.WriteMessage(websocket.BinaryMessage, []byte("state")); err != nil {
+        t.Fatal(err)
+    }
+    for _, peer := range []*websocket.Conn{a, b} {
+        if msg := readMessage(t, peer); string(msg) != "state" {
+            t.Errorf("peer got %q, want state", msg)
+        }
+    }
+
+    // A peer's messages reach the host alone.
+    if err := a.WriteMessage(websocket.BinaryMessage, []byte("input")); err != nil {
+        t.Fatal(err)
+    }
+    if msg := readMessage(t, host); string(msg) != "input" {
+        t.Errorf("host got %q, want input", msg)
+    }
+    // A timed out read leaves the connection unusable, so only b is
+    // checked for silence.
+    expectSilence(t, b)
+
+    // Without a host the room is torn down.
+    host.Close()
+    expectClose(t, a, websocket.CloseNormalClosure)
+    waitFor(t, "the room to be removed", func() bool {
+        rl.mu.Lock()
+        defer rl.mu.Unlock()
+        return rl.rooms["r"] == nil
+    })
+}
+
+func TestHostedSequenceNumbers(t *testing.T) {
+    rl := newRelay(3, 0)
+    rl.seq = true
+    url := startRelay(t, rl)
+    host, _ := dialRoom(t, url, "room=r&mode=host")
+    a, _ := dialRoom(t, url, "room=r")
+    b, _ := dialRoom(t, url, "room=r")
+
+    // Each side numbers only what it receives, so neither sees a gap when
+    // the other direction is busy.
+    send := func(from *websocket.Conn, msg string) {
+        t.Helper()
+        if err := from.WriteMessage(websocket.BinaryMessage, []byte(msg)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    expect := func(conn *websocket.Conn, n uint64, want string) {
+        t.Helper()
+        frame := readMessage(t, conn)
+        if got := binary.LittleEndian.Uint64(frame); got != n ||
+                string(frame[seqHeaderSize:]) != want {
+            t.Errorf("got %d %q, want %d %q", got, frame[seqHeaderSize:], n, want)
+        }
+    }
+    for n := uint64(1); n <= 4; n++ {
+        send(host, "state")
+        expect(a, n, "state")
+        expect(b, n, "state")
+        send([]*websocket.Conn{a, b}[n%2], "input")
+        expect(host, n, "input")
+    }
+}
+
+func TestDrain(t *testing.T) {
+    rl := newRelay(4, time.Minute)
+    url := startRelay(t, rl)
+    a, _ := dialRoom(t, url, "room=r")
+    b, _ := dialRoom(t, url, "room=r")
+
+    errc := make(chan error, 1)
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        errc <- rl.drain(ctx)
+    }()
+    expectClose(t, a, websocket.CloseGoingAway)
+    expectClose(t, b, websocket.CloseGoingAway)
+    if err := <-errc; err != nil {
+        t.Errorf("drain: %v", err)
+    }
+
+    _, resp, err := websocket.DefaultDialer.Dial(url+"/ws?room=r", nil)
+    if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+        t.Errorf("dial while draining: %v, %v; want 503", resp, err)
+    }
+}
+
+func TestDrainTimeout(t *testing.T) {
+    rl := newRelay(4, time.Minute)
+    type cut struct {
+        code   int
+        reason string
+    }
+    cuts := make(chan cut, 1)
+    // The client ignores the request to go away.
+    rl.join("r", roomOptions{}, &client{
+        goAway: func() {},
+        abort:  func(code int, reason string) { cuts <- cut{code, reason} },
+    })
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+    defer cancel()
+    if err := rl.drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("drain: %v, want deadline exceeded", err)
+    }
+    select {
+    case c := <-cuts:
+        if c.code != websocket.CloseGoingAway || c.reason != shutdownReason {
+            t.Errorf("aborted with %d %q, want %d %q",
+                c.code, c.reason, websocket.CloseGoingAway, shutdownReason)
+        }
+    default:
+        t.Error("client was not aborted")
+    }
+}
+
+func TestLogFrames(t *testing.T) {
+    var buf bytes.Buffer
+    prev := log.Writer()
+    log.SetOutput(&buf)
+    t.Cleanup(func() { log.SetOutput(prev) })
+
+    (&client{id: "quiet"}).received("message", []byte("abc"))
+    c := &client{id: "req-1", logFrames: true}
+    c.received("message", []byte("abc"))
+    c.received("datagram", []byte("hello"))
+
+    out := buf.String()
+    for _, want := range []string{
+        "[req-1] message 1: 3 bytes",
+        "[req-1] datagram 2: 5 bytes",
+    } {
+        if !strings.Contains(out, want) {
+            t.Errorf("log %q does not contain %q", out, want)
+        }
+    }
+    if strings.Contains(out, "[quiet]") {
+        t.Errorf("frame logged without logFrames: %q", out)
+    }
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "net/http"
+)
+
+type requestIDKey struct{}
+
+// withRequestID tags every request with an ID, available from the request
+// context and echoed in the X-Request-ID response header. A well-formed ID
+// sent by the client or a proxy in front is kept, and a random one is
+// generated otherwise.
+func withRequestID(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        id := r.Header.Get("X-Request-ID")
+        if !validRequestID(id) {
+            id = rand.Text()
+        }
+        w.Header().Set("X-Request-ID", id)
+        ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+        next.ServeHTTP(w, r.WithContext(ctx))
+    })
+}
+
+// requestID returns the ID withRequestID gave a request, or "" if it did
+// not pass through it.
+func requestID(ctx context.Context) string {
+    id, _ := ctx.Value(requestIDKey{}).(string)
+    return id
+}
+
+// validRequestID accepts up to 128 characters that are safe to copy into a
+// header and a log line.
+func validRequestID(id string) bool {
+    if id == "" || len(id) > 128 {
+        return false
+    }
+    for _, c := range []byte(id) {
+        ok := 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+            '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.'
+        if !ok {
+            return false
+        }
+    }
+    return true
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestRequestID(t *testing.T) {
+    var seen string
+    h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        seen = requestID(r.Context())
+    }))
+
+    for _, tt := range []struct {
+        name, sent string
+        echoed     bool
+    }{
+        {"well formed", "req-42_a.b", true},
+        {"missing", "", false},
+        {"header injection", "a\r\nSet-Cookie: x", false},
+        {"too long", strings.Repeat("a", 129), false},
+    } {
+        var header []string
+        if tt.sent != "" {
+            header = []string{"X-Request-ID", tt.sent}
+        }
+        id := get(h, "/", header...).Header().Get("X-Request-ID")
+        if id != seen {
+            t.Errorf("%s: header %q but context %q", tt.name, id, seen)
+        }
+        if tt.echoed {
+            if id != tt.sent {
+                t.Errorf("%s: got ID %q, want %q echoed", tt.name, id, tt.sent)
+            }
+        } else if id == tt.sent || !validRequestID(id) {
+            t.Errorf("%s: generated ID %q is not well formed", tt.name, id)
+        }
+    }
+
+    first := get(h, "/").Header().Get("X-Request-ID")
+    if second := get(h, "/").Header().Get("X-Request-ID"); first == second {
+        t.Errorf("two requests were both given ID %q", first)
+    }
+}
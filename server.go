@@ -1,16 +1,322 @@
 package main
 
 import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "io/fs"
     "log"
     "net/http"
+    "os"
+    "os/signal"
+    "regexp"
+    "slices"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+
+    "github.com/quic-go/quic-go"
+    "golang.org/x/net/http2"
+    "golang.org/x/net/http2/h2c"
+    "golang.org/x/text/language"
+    "gopkg.in/natefinch/lumberjack.v2"
 )
 
+// stringList is a flag that may be repeated to collect several values.
+type stringList []string
+
+func (l *stringList) String() string {
+    return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+    *l = append(*l, v)
+    return nil
+}
+
 func main() {
-    http.HandleFunc(
-        "/",
-        func(w http.ResponseWriter, r *http.Request) {
-            http.ServeFile(w, r, "zig-out/htmlout/"+r.URL.Path[1:])
+    var cfg Config
+    if err := cfg.parseConfig(flag.CommandLine, os.Args[1:]); err != nil {
+        log.Fatal(err)
+    }
+    explicitRoots := cfg.resolve()
+    if err := cfg.validate(); err != nil {
+        log.Fatal(err)
+    }
+    if cfg.DryRun {
+        if err := dryRun(&cfg, explicitRoots, os.Stdout); err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            os.Exit(1)
+        }
+        return
+    }
+
+    probes := &health{}
+
+    tlsConf, certManager, err := tlsConfig(cfg.TLS.Cert, cfg.TLS.Key,
+        cfg.TLS.AutocertDomain, cfg.TLS.AutocertCache)
+    if err != nil {
+        log.Fatal(err)
+    }
+    allowNets, _ := parseCIDRs(cfg.AllowCIDRs)
+    denyNets, _ := parseCIDRs(cfg.DenyCIDRs)
+
+    var logOut io.Writer = os.Stderr
+    var rotator *lumberjack.Logger
+    if cfg.Log.File != "" {
+        rotator = &lumberjack.Logger{
+            Filename:   cfg.Log.File,
+            MaxSize:    cfg.Log.MaxSize,
+            MaxBackups: cfg.Log.MaxBackups,
+            MaxAge:     cfg.Log.MaxAge,
+        }
+        logOut = rotator
+        if cfg.Log.Stderr {
+            logOut = io.MultiWriter(rotator, os.Stderr)
+        }
+    }
+    logSink := newAsyncWriter(logOut, 4096)
+    accessLog, err := newAccessLogger(cfg.Log.Format, logSink)
+    if err != nil {
+        log.Fatal(err)
+    }
+
+    if err := registerMimeTypes(); err != nil {
+        log.Fatal(err)
+    }
+    var handler http.Handler
+    var source string
+    var reload *liveReload
+    fsys, err := embeddedFiles()
+    if err != nil {
+        log.Printf("not using embedded files: %v", err)
+    }
+    if fsys != nil && !explicitRoots {
+        handler = http.FileServer(http.FS(fsys))
+        source = "embedded files"
+    } else {
+        files, err := newFileHandler(cfg.Roots...)
+        if err != nil {
+            log.Fatal(err)
+        }
+        files.spa = cfg.SPA
+        files.listing = cfg.Listing
+        files.defaultLang, _ = language.Parse(cfg.DefaultLang)
+        if cfg.CacheSize > 0 {
+            files.cache = newAssetCache(cfg.CacheSize, cfg.CacheMaxFileSize)
+        }
+        files.preload = slices.DeleteFunc(cfg.Preload, func(p string) bool {
+            return p == ""
         })
+        if cfg.Immutable != "" {
+            files.immutable = regexp.MustCompile(cfg.Immutable)
+        }
+        if cfg.Dev {
+            reload = newLiveReload()
+            for _, root := range files.roots {
+                watcher, err := reload.watch(root, 200*time.Millisecond)
+                if err != nil {
+                    log.Fatalf("watching %s: %v", root, err)
+                }
+                defer watcher.Close()
+            }
+            files.devScript = liveReloadTag
+        }
+        if cfg.SRI {
+            files.sri = newSRICache(cfg.Dev)
+        }
+        handler = files
+        source = strings.Join(files.roots, ", ")
+    }
+    if cfg.GzipMinSize >= 0 {
+        handler = gzipHandler(handler, cfg.GzipMinSize)
+    }
+    if cfg.CSP.Policy != "" {
+        handler = contentSecurityPolicy(handler, cfg.CSP.Policy, cfg.CSP.ReportOnly)
+    }
+    if cfg.COI {
+        handler = crossOriginIsolate(handler)
+    }
+    if len(cfg.Headers) > 0 {
+        extra, _ := parseHeaders(cfg.Headers)
+        handler = addHeaders(handler, extra)
+    }
+    mux := http.NewServeMux()
+    mux.HandleFunc("/healthz", probes.healthz)
+    mux.HandleFunc("/readyz", probes.readyz)
+    mux.HandleFunc("/version", serveVersion)
+    mux.Handle("/", handler)
+    lobby := newRelay(cfg.Relay.MaxPlayers, cfg.Relay.ReconnectGrace.Duration)
+    lobby.seq = cfg.Relay.Seq
+    lobby.logFrames = cfg.Relay.LogFrames
+    go lobby.expireRooms(cfg.Relay.RoomTTL.Duration)
+    mux.Handle("/ws", lobby)
+    mux.HandleFunc("POST /rooms", lobby.handleCreateRoom)
+    mux.HandleFunc("GET /rooms/{code}", lobby.handleRoomStatus)
+    if reload != nil {
+        mux.Handle("/livereload", reload)
+        mux.HandleFunc("/livereload.js", serveLiveReloadClient)
+    }
+    var stats *metrics
+    if cfg.Metrics {
+        stats = newMetrics(mux)
+        mux.Handle("/metrics", stats.handler())
+    }
+
+    if cfg.CORS.Credentials && slices.Contains(cfg.CORS.Origins, "*") {
+        log.Print("cors.credentials has no effect with cors.origins *")
+    }
+    var assets, sockets *rateLimiter
+    if limits := cfg.RateLimit; limits.Requests > 0 {
+        assets = newRateLimiter(limits.Requests, limits.Burst)
+        go assets.evict(max(3*time.Minute, assets.refill()))
+    }
+    if limits := cfg.RateLimit; limits.Sockets > 0 {
+        sockets = newRateLimiter(limits.Sockets, limits.SocketBurst)
+        go sockets.evict(max(3*time.Minute, sockets.refill()))
+    }
+    // The chain is shared with WebTransport, so that /wt passes the same
+    // checks and is logged like everything served over TCP.
+    middleware := func(h http.Handler) http.Handler {
+        if cfg.Auth.User != "" {
+            h = basicAuth(h,
+                passwordChecker(cfg.Auth.User, cfg.Auth.Pass, cfg.Auth.Hash),
+                "/healthz", "/readyz")
+        }
+        if origins := cfg.CORS.Origins; len(origins) > 0 {
+            h = cors(h, origins, cfg.CORS.Credentials)
+        }
+        if assets != nil || sockets != nil {
+            h = rateLimit(h, assets, sockets, cfg.TrustProxy)
+        }
+        if len(allowNets) > 0 || len(denyNets) > 0 {
+            h = ipFilter(h, allowNets, denyNets, cfg.TrustProxy)
+        }
+        h = recoverPanics(h)
+        if accessLog != nil || stats != nil {
+            h = logRequests(h, accessLog, stats)
+        }
+        return withRequestID(h)
+    }
+
+    app := middleware(mux)
+    if cfg.H2C {
+        app = h2c.NewHandler(app, &http2.Server{})
+    }
+    servers := []*http.Server{
+        {Addr: cfg.Addr, Handler: app, TLSConfig: tlsConf},
+    }
+    if cfg.TLS.RedirectAddr != "" {
+        var redirect http.Handler = redirectHTTPS(cfg.Addr)
+        if certManager != nil {
+            redirect = certManager.HTTPHandler(redirect)
+        }
+        servers = append(servers,
+            &http.Server{Addr: cfg.TLS.RedirectAddr, Handler: redirect})
+    }
+
+    for _, srv := range servers {
+        srv.ReadHeaderTimeout = cfg.Timeouts.ReadHeader.Duration
+        srv.ReadTimeout = cfg.Timeouts.Read.Duration
+        srv.WriteTimeout = cfg.Timeouts.Write.Duration
+        srv.IdleTimeout = cfg.Timeouts.Idle.Duration
+    }
+    servers[0].RegisterOnShutdown(func() { probes.ready.Store(false) })
+
+    // Relay clients are told to go away while the HTTP servers shut down,
+    // and WebTransport is closed once they have.
+    drain := lobby.drain
+    if cfg.Relay.WebTransport {
+        wt := newWebTransport(cfg.Addr, tlsConf, lobby, middleware)
+        drain = func(ctx context.Context) error {
+            err := lobby.drain(ctx)
+            wt.Close()
+            return err
+        }
+        go func() {
+            if err := wt.ListenAndServe(); err != nil && !errors.Is(err, quic.ErrServerClosed) {
+                log.Printf("webtransport: %v", err)
+            }
+        }()
+    }
+
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+    scheme := "http"
+    if tlsConf != nil {
+        scheme = "https"
+    }
+    log.Printf("serving %s on %s (%s)", source, cfg.Addr, scheme)
+    probes.ready.Store(true)
+    if cfg.Open {
+        go launchBrowser(cfg.Addr, scheme)
+    }
+    socketMode, _ := strconv.ParseUint(cfg.SocketMode, 8, 32)
+    lc := listenConfig{maxConns: cfg.MaxConns, socketMode: fs.FileMode(socketMode)}
+    err = run(servers, stop, cfg.ShutdownTimeout.Duration, lc, drain)
+    logSink.Close()
+    if rotator != nil {
+        rotator.Close()
+    }
+    if err != nil {
+        log.Fatal(err)
+    }
+}
+
+// run serves until a server fails or a signal arrives on stop, then gives
+// in-flight requests up to timeout to finish. Servers with a TLS config
+// serve HTTPS on listeners opened as lc says. The drain functions run
+// alongside the servers' shutdown, for connections that Shutdown does not
+// wait on such as WebSockets.
+func run(
+    servers []*http.Server, stop <-chan os.Signal, timeout time.Duration,
+    lc listenConfig, drains ...func(context.Context) error,
+) error {
+    errc := make(chan error, len(servers))
+    for _, srv := range servers {
+        ln, err := lc.listen(srv.Addr)
+        if err != nil {
+            errc <- err
+            continue
+        }
+        go func() {
+            if srv.TLSConfig != nil {
+                errc <- srv.ServeTLS(ln, "", "")
+            } else {
+                errc <- srv.Serve(ln)
+            }
+        }()
+    }
+
+    var err error
+    select {
+    case err = <-errc:
+    case sig := <-stop:
+        log.Printf("received %v, shutting down", sig)
+    }
 
-    log.Fatal(http.ListenAndServe(":8083", nil))
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    shutdowns := drains
+    for _, srv := range servers {
+        shutdowns = append(shutdowns, srv.Shutdown)
+    }
+    errs := make([]error, len(shutdowns))
+    var wg sync.WaitGroup
+    for i, shutdown := range shutdowns {
+        wg.Go(func() { errs[i] = shutdown(ctx) })
+    }
+    wg.Wait()
+    if serr := errors.Join(errs...); serr != nil && err == nil {
+        err = fmt.Errorf("shutdown: %w", serr)
+    }
+    if err == nil {
+        log.Print("shutdown complete")
+    }
+    return err
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "syscall"
+    "testing"
+    "time"
+
+    "golang.org/x/net/http2"
+    "golang.org/x/net/http2/h2c"
+)
+
+// freeAddr returns a loopback address with a port nothing is listening on.
+func freeAddr(t *testing.T) string {
+    t.Helper()
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer ln.Close()
+    return ln.Addr().String()
+}
+
+// waitForServer polls url until it answers.
+func waitForServer(t *testing.T, url string) *http.Response {
+    t.Helper()
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        resp, err := http.Get(url)
+        if err == nil {
+            return resp
+        }
+        if time.Now().After(deadline) {
+            t.Fatal(err)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestRunShutdown(t *testing.T) {
+    addr := freeAddr(t)
+    srv := &http.Server{Addr: addr, Handler: hello}
+    stop := make(chan os.Signal, 1)
+    drained := false
+    drain := func(ctx context.Context) error {
+        drained = true
+        return nil
+    }
+    errc := make(chan error, 1)
+    go func() { errc <- run([]*http.Server{srv}, stop, time.Second, listenConfig{}, drain) }()
+
+    resp := waitForServer(t, "http://"+addr+"/")
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if string(body) != "<p>hello</p>" {
+        t.Errorf("body %q", body)
+    }
+
+    stop <- syscall.SIGTERM
+    select {
+    case err := <-errc:
+        if err != nil {
+            t.Errorf("run: %v", err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("run did not return after the signal")
+    }
+    if !drained {
+        t.Error("drain was not called")
+    }
+    if _, err := http.Get("http://" + addr + "/"); err == nil {
+        t.Error("server still answering after shutdown")
+    }
+}
+
+func TestH2C(t *testing.T) {
+    srv := httptest.NewServer(h2c.NewHandler(hello, &http2.Server{}))
+    defer srv.Close()
+    client := &http.Client{Transport: &http2.Transport{
+        AllowHTTP: true,
+        DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+            var d net.Dialer
+            return d.DialContext(ctx, network, addr)
+        },
+    }}
+    resp, err := client.Get(srv.URL)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    body, _ := io.ReadAll(resp.Body)
+    if resp.ProtoMajor != 2 || string(body) != "<p>hello</p>" {
+        t.Errorf("%s %q, want HTTP/2.0", resp.Proto, body)
+    }
+}
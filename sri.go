@@ -0,0 +1,149 @@
+package main
+
+import (
+    "crypto/sha256"
+    "crypto/sha512"
+    "encoding/base64"
+    "encoding/hex"
+    "io"
+    "net/url"
+    "os"
+    "path"
+    "regexp"
+    "strings"
+    "sync"
+    "time"
+)
+
+var (
+    sriTagPattern  = regexp.MustCompile(`(?is)<(script|link)\b[^>]*>`)
+    sriAttrPattern = regexp.MustCompile(
+        `(?is)\s([a-z-]+)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+)))?`)
+)
+
+// sriCache adds Subresource Integrity hashes to the scripts, stylesheets and
+// preloads an index page refers to. Each page is rewritten once, or again
+// whenever it or one of its assets changes when watch is set.
+type sriCache struct {
+    watch bool
+
+    mu      sync.Mutex
+    entries map[string]*sriEntry
+}
+
+type sriEntry struct {
+    doc  []byte
+    etag string
+    deps map[string]fileStamp
+}
+
+type fileStamp struct {
+    modTime time.Time
+    size    int64
+}
+
+func newSRICache(watch bool) *sriCache {
+    return &sriCache{watch: watch, entries: map[string]*sriEntry{}}
+}
+
+// get returns the rewritten copy of the index page name, read from src,
+// along with an ETag for it. Relative asset URLs are resolved against dir,
+// the URL path of the directory holding the page.
+func (c *sriCache) get(h *fileHandler, name, dir string, info os.FileInfo, src io.Reader) ([]byte, string, error) {
+    c.mu.Lock()
+    e := c.entries[name]
+    c.mu.Unlock()
+    if e != nil && (!c.watch || e.fresh()) {
+        return e.doc, e.etag, nil
+    }
+
+    doc, err := io.ReadAll(src)
+    if err != nil {
+        return nil, "", err
+    }
+    e = &sriEntry{deps: map[string]fileStamp{
+        name: {modTime: info.ModTime(), size: info.Size()},
+    }}
+    e.doc = sriTagPattern.ReplaceAllFunc(doc, func(tag []byte) []byte {
+        return h.addIntegrity(tag, dir, e.deps)
+    })
+    sum := sha256.Sum256(e.doc)
+    e.etag = `"` + hex.EncodeToString(sum[:8]) + `"`
+
+    c.mu.Lock()
+    c.entries[name] = e
+    c.mu.Unlock()
+    return e.doc, e.etag, nil
+}
+
+func (e *sriEntry) fresh() bool {
+    for name, stamp := range e.deps {
+        info, err := os.Stat(name)
+        if err != nil || !info.ModTime().Equal(stamp.modTime) || info.Size() != stamp.size {
+            return false
+        }
+    }
+    return true
+}
+
+// addIntegrity adds integrity and crossorigin attributes to a script or
+// link tag referring to a local file, recording the file in deps. Tags that
+// already have an integrity attribute, refer to other origins or are links
+// that do not load a subresource are returned unchanged.
+func (h *fileHandler) addIntegrity(tag []byte, dir string, deps map[string]fileStamp) []byte {
+    attrs := map[string]string{}
+    for _, m := range sriAttrPattern.FindAllSubmatch(tag, -1) {
+        attrs[strings.ToLower(string(m[1]))] = string(m[2]) + string(m[3]) + string(m[4])
+    }
+    if _, ok := attrs["integrity"]; ok {
+        return tag
+    }
+    ref := attrs["src"]
+    if strings.EqualFold(string(tag[1:5]), "link") {
+        switch strings.ToLower(attrs["rel"]) {
+        case "preload", "modulepreload", "stylesheet":
+            ref = attrs["href"]
+        default:
+            return tag
+        }
+    }
+    u, err := url.Parse(ref)
+    if ref == "" || err != nil || u.Scheme != "" || u.Host != "" {
+        return tag
+    }
+    urlPath := u.Path
+    if !strings.HasPrefix(urlPath, "/") {
+        urlPath = path.Join(dir, urlPath)
+    }
+    name, err := h.resolve(urlPath)
+    if err != nil {
+        return tag
+    }
+    f, err := os.Open(name)
+    if err != nil {
+        return tag
+    }
+    defer f.Close()
+    info, err := f.Stat()
+    if err != nil || !info.Mode().IsRegular() {
+        return tag
+    }
+    hash := sha512.New384()
+    if _, err := io.Copy(hash, f); err != nil {
+        return tag
+    }
+    deps[name] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+
+    extra := ` integrity="sha384-` + base64.StdEncoding.EncodeToString(hash.Sum(nil)) + `"`
+    if _, ok := attrs["crossorigin"]; !ok {
+        extra += ` crossorigin="anonymous"`
+    }
+    end := len(tag) - 1
+    if tag[end-1] == '/' {
+        end--
+    }
+    out := make([]byte, 0, len(tag)+len(extra))
+    out = append(out, tag[:end]...)
+    out = append(out, extra...)
+    return append(out, tag[end:]...)
+}
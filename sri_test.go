@@ -0,0 +1,45 @@
+package main
+
+import (
+    "crypto/sha512"
+    "encoding/base64"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestSRI(t *testing.T) {
+    root := writeTree(t, map[string]string{
+        "index.html": `<link rel="preload" href="/app.wasm" as="fetch">` +
+            `<script src="app.js"></script><script src="https://cdn.example/x.js"></script>`,
+        "app.js":   "console.log(1)",
+        "app.wasm": "\x00asm",
+    })
+    h := newTestFileHandler(t, root)
+    h.spa = true
+    h.sri = newSRICache(false)
+
+    integrity := func(content string) string {
+        sum := sha512.Sum384([]byte(content))
+        return `integrity="sha384-` + base64.StdEncoding.EncodeToString(sum[:]) + `"`
+    }
+    for _, target := range []string{"/", "/a/b", "/c/d", "/e/f/g"} {
+        rec := get(h, target)
+        body := rec.Body.String()
+        if rec.Code != http.StatusOK {
+            t.Fatalf("GET %s: %d", target, rec.Code)
+        }
+        for _, want := range []string{
+            `<script src="app.js" ` + integrity("console.log(1)") + ` crossorigin="anonymous">`,
+            `href="/app.wasm" as="fetch" ` + integrity("\x00asm"),
+            `<script src="https://cdn.example/x.js">`,
+        } {
+            if !strings.Contains(body, want) {
+                t.Errorf("GET %s: body %s\nlacks %s", target, body, want)
+            }
+        }
+    }
+    if n := len(h.sri.entries); n != 1 {
+        t.Errorf("%d cached pages for one index.html, want 1", n)
+    }
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "mime"
+    "net/http"
+    "net/url"
+    "os"
+    "path"
+    "path/filepath"
+    "regexp"
+    "slices"
+    "strings"
+
+    "golang.org/x/text/language"
+)
+
+var (
+    errOutsideRoot = errors.New("path escapes root directory")
+    errBadPath     = errors.New("malformed path")
+)
+
+// fileHandler serves files from an ordered list of root directories, as
+// though they were overlaid with the first taking precedence.
+type fileHandler struct {
+    roots []string
+    // spa serves index.html in place of missing extensionless paths so
+    // that client-side routes can be deep linked.
+    spa bool
+    // immutable matches fingerprinted file names that may be cached
+    // forever.
+    immutable *regexp.Regexp
+    etags     *etagCache
+    // cache holds hot files in memory, and is nil when disabled.
+    cache *assetCache
+    // devScript is injected into HTML documents in development mode.
+    devScript string
+    // listing renders an index of directories that have no index.html.
+    listing bool
+    // preload lists the URL paths announced in Link headers on index.html.
+    // When it is nil they are detected from the files next to the index.
+    preload []string
+    // sri adds integrity hashes to the assets index pages load, and is nil
+    // when disabled.
+    sri *sriCache
+    // defaultLang picks the localized index for clients whose
+    // Accept-Language matches none of them.
+    defaultLang language.Tag
+}
+
+func newFileHandler(roots ...string) (*fileHandler, error) {
+    h := &fileHandler{etags: newETagCache()}
+    for _, root := range roots {
+        if err := checkRoot(root); err != nil {
+            return nil, err
+        }
+        abs, err := filepath.Abs(root)
+        if err != nil {
+            return nil, err
+        }
+        resolved, err := filepath.EvalSymlinks(abs)
+        if err != nil {
+            return nil, err
+        }
+        h.roots = append(h.roots, resolved)
+    }
+    if len(h.roots) == 0 {
+        return nil, errors.New("no root directories")
+    }
+    return h, nil
+}
+
+// checkRoot makes sure root is a directory, so that a server started before
+// the build has run fails loudly instead of answering everything with 404.
+// An empty directory is allowed, since the build may be about to fill it,
+// but gets a warning.
+func checkRoot(root string) error {
+    info, err := os.Stat(root)
+    if errors.Is(err, os.ErrNotExist) {
+        return fmt.Errorf("root directory %s does not exist; "+
+            "run `zig build` first or pass -root", root)
+    }
+    if err != nil {
+        return fmt.Errorf("root directory %s: %w", root, err)
+    }
+    if !info.IsDir() {
+        return fmt.Errorf("root %s is not a directory", root)
+    }
+    entries, err := os.ReadDir(root)
+    if err != nil {
+        return fmt.Errorf("root directory %s: %w", root, err)
+    }
+    if len(entries) == 0 {
+        log.Printf("warning: root directory %s is empty", root)
+    }
+    return nil
+}
+
+// resolve maps a URL path onto the first root that has a file there. When
+// none do, it returns the path under the first root.
+func (h *fileHandler) resolve(urlPath string) (string, error) {
+    var first string
+    for i, root := range h.roots {
+        name, err := resolveIn(root, urlPath)
+        if err != nil {
+            return "", err
+        }
+        if _, err := os.Stat(name); err == nil {
+            return name, nil
+        }
+        if i == 0 {
+            first = name
+        }
+    }
+    return first, nil
+}
+
+func (h *fileHandler) exists(urlPath string) bool {
+    name, err := h.resolve(urlPath)
+    if err != nil {
+        return false
+    }
+    _, err = os.Stat(name)
+    return err == nil
+}
+
+// resolveIn maps a URL path onto a file below root, following symlinks so
+// that a link inside the tree cannot be used to reach files outside it.
+func resolveIn(root, urlPath string) (string, error) {
+    joined, err := resolvePath(root, urlPath)
+    if err != nil {
+        return "", err
+    }
+    real, err := filepath.EvalSymlinks(joined)
+    if err == nil {
+        joined = real
+    } else if !errors.Is(err, os.ErrNotExist) {
+        return "", err
+    }
+    if !within(root, joined) {
+        return "", errOutsideRoot
+    }
+    return joined, nil
+}
+
+// maxPathLen bounds the URL paths resolvePath accepts, well below what
+// any filesystem allows.
+const maxPathLen = 4096
+
+// resolvePath maps a URL path onto a file name below root without touching
+// the filesystem. It refuses paths that would step outside root through ..
+// segments, whether separated by forward or back slashes, as well as null
+// bytes, slashes that are still percent-encoded after the URL was decoded
+// once, and paths too long to be a real file.
+func resolvePath(root, urlPath string) (string, error) {
+    if len(urlPath) > maxPathLen || strings.ContainsRune(urlPath, 0) {
+        return "", errBadPath
+    }
+    if lower := strings.ToLower(urlPath); strings.Contains(lower, "%2f") ||
+            strings.Contains(lower, "%5c") || strings.Contains(lower, "%00") {
+        return "", errBadPath
+    }
+    for _, part := range strings.FieldsFunc(urlPath, isSlash) {
+        if part == ".." {
+            return "", errOutsideRoot
+        }
+    }
+    clean := path.Clean("/" + strings.ReplaceAll(urlPath, `\`, "/"))
+    joined := filepath.Join(root, filepath.FromSlash(clean))
+    if !within(root, joined) {
+        return "", errOutsideRoot
+    }
+    return joined, nil
+}
+
+// within reports whether name is root or lies below it.
+func within(root, name string) bool {
+    rel, err := filepath.Rel(root, name)
+    return err == nil && rel != ".." &&
+        !strings.HasPrefix(rel, ".."+string(filepath.Separator)) &&
+        !filepath.IsAbs(rel)
+}
+
+func (h *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    urlPath := r.URL.Path
+    name, err := h.resolve(urlPath)
+    if err != nil {
+        h.notFound(w, r)
+        return
+    }
+
+    info, err := os.Stat(name)
+    switch {
+    case err == nil && info.IsDir():
+        // Relative asset URLs in an index only resolve against the
+        // directory when the URL ends in a slash.
+        if !strings.HasSuffix(urlPath, "/") {
+            redirectToDir(w, r)
+            return
+        }
+        index := h.index(w, r, urlPath)
+        if h.listing && !h.exists(urlPath+index) {
+            h.serveListing(w, r, urlPath)
+            return
+        }
+        urlPath += index
+    case errors.Is(err, os.ErrNotExist) && h.spa &&
+            !strings.Contains(path.Base(urlPath), "."):
+        urlPath = "/" + h.index(w, r, "/")
+    default:
+        h.serve(w, r, urlPath, name)
+        return
+    }
+
+    if name, err = h.resolve(urlPath); err != nil {
+        h.notFound(w, r)
+        return
+    }
+    h.serve(w, r, urlPath, name)
+}
+
+func (h *fileHandler) serve(w http.ResponseWriter, r *http.Request, urlPath, name string) {
+    if isIndex(path.Base(urlPath)) {
+        for _, asset := range h.preloads(path.Dir(urlPath)) {
+            w.Header().Add("Link", preloadLink(asset))
+        }
+    }
+    if h.servePrecompressed(w, r, urlPath, name) {
+        return
+    }
+    h.serveFile(w, r, name)
+}
+
+// preloads returns the assets an index in dir should ask the browser to
+// start fetching before it has parsed the page. Unless configured, that is
+// the directory's .wasm module and its loader script, as emscripten names
+// them, when there is exactly one module.
+func (h *fileHandler) preloads(dir string) []string {
+    if h.preload != nil {
+        return h.preload
+    }
+    var modules []string
+    for _, root := range h.roots {
+        name, err := resolveIn(root, dir)
+        if err != nil {
+            continue
+        }
+        entries, err := os.ReadDir(name)
+        if err != nil {
+            continue
+        }
+        for _, e := range entries {
+            if e.Type().IsRegular() && path.Ext(e.Name()) == ".wasm" &&
+                    !slices.Contains(modules, e.Name()) {
+                modules = append(modules, e.Name())
+            }
+        }
+    }
+    if len(modules) != 1 {
+        return nil
+    }
+    module := path.Join(dir, modules[0])
+    assets := []string{module}
+    if script := strings.TrimSuffix(module, ".wasm") + ".js"; h.exists(script) {
+        assets = append(assets, script)
+    }
+    return assets
+}
+
+// preloadLink formats a Link header value preloading asset. Modules are
+// fetched in CORS mode by WebAssembly.instantiateStreaming, so their
+// preload must be too or the browser will not reuse it.
+func preloadLink(asset string) string {
+    u := (&url.URL{Path: asset}).EscapedPath()
+    switch path.Ext(asset) {
+    case ".js", ".mjs":
+        return "<" + u + ">; rel=preload; as=script"
+    case ".css":
+        return "<" + u + ">; rel=preload; as=style"
+    }
+    return "<" + u + ">; rel=preload; as=fetch; crossorigin"
+}
+
+// redirectToDir sends a directory request without its trailing slash to the
+// slashed form. The target is relative and escaped so that names containing
+// '?', '%' or ':' survive the round trip.
+func redirectToDir(w http.ResponseWriter, r *http.Request) {
+    target := (&url.URL{Path: "./" + path.Base(r.URL.Path) + "/"}).EscapedPath()
+    if r.URL.RawQuery != "" {
+        target += "?" + r.URL.RawQuery
+    }
+    w.Header().Set("Location", target)
+    w.WriteHeader(http.StatusMovedPermanently)
+}
+
+func (h *fileHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+    f, err := os.Open(name)
+    if err != nil {
+        h.serveError(w, r, err)
+        return
+    }
+    defer f.Close()
+    info, err := f.Stat()
+    if err != nil {
+        h.serveError(w, r, err)
+        return
+    }
+    if info.IsDir() {
+        h.notFound(w, r)
+        return
+    }
+
+    if tag, err := h.etags.get(name, f, info); err == nil {
+        w.Header().Set("ETag", tag)
+    } else {
+        log.Printf("hashing %s: %v", name, err)
+    }
+    original := strings.TrimSuffix(name,
+        precompressedExts[w.Header().Get("Content-Encoding")])
+    setCacheControl(w.Header(), original, h.immutable)
+    // ServeContent checks If-None-Match before If-Modified-Since, and
+    // compares the latter against this same truncated time, so both
+    // validators agree on whether to answer 304.
+    modTime := lastModified(info)
+    if !modTime.IsZero() {
+        w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+    }
+    var content io.ReadSeeker = f
+    if h.cache != nil {
+        if data, err := h.cache.get(name, f, info); err != nil {
+            h.serveError(w, r, err)
+            return
+        } else if data != nil {
+            content = bytes.NewReader(data)
+        }
+    }
+    if h.rewrites(name) {
+        var doc []byte
+        if h.sri != nil && isIndex(info.Name()) {
+            var tag string
+            doc, tag, err = h.sri.get(h, name, h.urlDir(name), info, content)
+            w.Header().Set("ETag", tag)
+        } else {
+            doc, err = io.ReadAll(content)
+        }
+        if err != nil {
+            h.serveError(w, r, err)
+            return
+        }
+        if h.devScript != "" {
+            doc = injectScript(doc, h.devScript)
+        }
+        http.ServeContent(w, r, info.Name(), modTime, bytes.NewReader(doc))
+        return
+    }
+    http.ServeContent(w, r, info.Name(), modTime, content)
+}
+
+// urlDir returns the URL path of the directory holding name, a file below
+// one of the roots. An index served in place of a client-side route is
+// still handled as the file it is, so that requests for made up paths do
+// not each get their own copy.
+func (h *fileHandler) urlDir(name string) string {
+    dir := filepath.Dir(name)
+    for _, root := range h.roots {
+        if within(root, dir) {
+            rel, _ := filepath.Rel(root, dir)
+            return path.Join("/", filepath.ToSlash(rel))
+        }
+    }
+    return "/"
+}
+
+// rewrites reports whether a file is altered on its way out rather than
+// served as stored, which rules out serving a precompressed copy.
+func (h *fileHandler) rewrites(name string) bool {
+    return isHTML(name) &&
+        (h.devScript != "" || h.sri != nil && isIndex(filepath.Base(name)))
+}
+
+// notFound serves 404.html from the roots when there is one, and Go's plain
+// text 404 otherwise.
+func (h *fileHandler) notFound(w http.ResponseWriter, r *http.Request) {
+    name, err := h.resolve("/404.html")
+    if err != nil {
+        http.NotFound(w, r)
+        return
+    }
+    f, err := os.Open(name)
+    if err != nil {
+        http.NotFound(w, r)
+        return
+    }
+    defer f.Close()
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.WriteHeader(http.StatusNotFound)
+    if r.Method != http.MethodHead {
+        io.Copy(w, f)
+    }
+}
+
+func isHTML(name string) bool {
+    ext := filepath.Ext(name)
+    return ext == ".html" || ext == ".htm"
+}
+
+func (h *fileHandler) serveError(w http.ResponseWriter, r *http.Request, err error) {
+    switch {
+    case errors.Is(err, os.ErrNotExist):
+        h.notFound(w, r)
+    case errors.Is(err, os.ErrPermission):
+        http.Error(w, "403 Forbidden", http.StatusForbidden)
+    default:
+        http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+    }
+}
+
+var precompressedExts = map[string]string{
+    "br":   ".br",
+    "gzip": ".gz",
+}
+
+// servePrecompressed serves a sibling name.br or name.gz in place of name
+// when the client accepts that encoding, like nginx's gzip_static.
+func (h *fileHandler) servePrecompressed(
+    w http.ResponseWriter, r *http.Request, urlPath, name string,
+) bool {
+    info, err := os.Stat(name)
+    if err != nil || !info.Mode().IsRegular() {
+        return false
+    }
+    addVary(w.Header(), "Accept-Encoding")
+    if h.rewrites(name) {
+        return false
+    }
+    if r.Header.Get("Range") != "" {
+        return false
+    }
+
+    accept := r.Header.Get("Accept-Encoding")
+    offers := []string{"br", "gzip"}
+    for len(offers) > 0 {
+        enc := negotiateEncoding(accept, offers...)
+        if enc == "" {
+            return false
+        }
+        offers = slices.DeleteFunc(offers, func(o string) bool {
+            return o == enc
+        })
+
+        sibling, err := h.resolve(urlPath + precompressedExts[enc])
+        if err != nil {
+            continue
+        }
+        if info, err := os.Stat(sibling); err != nil || !info.Mode().IsRegular() {
+            continue
+        }
+        ctype := mime.TypeByExtension(filepath.Ext(name))
+        if ctype == "" {
+            ctype = "application/octet-stream"
+        }
+        w.Header().Set("Content-Type", ctype)
+        w.Header().Set("Content-Encoding", enc)
+        h.serveFile(w, r, sibling)
+        return true
+    }
+    return false
+}
+
+// registerMimeTypes pins the types browsers are strict about, since the
+// system mime tables often label .wasm as application/octet-stream.
+func registerMimeTypes() error {
+    if err := mime.AddExtensionType(".wasm", "application/wasm"); err != nil {
+        return err
+    }
+    return mime.AddExtensionType(".js", "text/javascript")
+}
+
+func isSlash(c rune) bool {
+    return c == '/' || c == '\\'
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// writeTree creates files, keyed by slash-separated paths, below a new
+// temporary directory and returns it.
+func writeTree(t *testing.T, files map[string]string) string {
+    t.Helper()
+    dir := t.TempDir()
+    for name, content := range files {
+        name = filepath.Join(dir, filepath.FromSlash(name))
+        if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return dir
+}
+
+// get sends h a GET for target with headers given as name, value pairs.
+func get(h http.Handler, target string, header ...string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest("GET", target, nil)
+    for i := 0; i+1 < len(header); i += 2 {
+        req.Header.Set(header[i], header[i+1])
+    }
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    return rec
+}
+
+func newTestFileHandler(t *testing.T, roots ...string) *fileHandler {
+    t.Helper()
+    h, err := newFileHandler(roots...)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return h
+}
+
+func TestTraversal(t *testing.T) {
+    dir := writeTree(t, map[string]string{
+        "root/index.html":  "index",
+        "root/a/page.html": "page",
+        "secret":           "secret",
+        "outside/secret":   "secret",
+    })
+    root := filepath.Join(dir, "root")
+    if err := os.Symlink(filepath.Join(dir, "secret"), filepath.Join(root, "link")); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Symlink(filepath.Join(dir, "outside"), filepath.Join(root, "dirlink")); err != nil {
+        t.Fatal(err)
+    }
+    h := newTestFileHandler(t, root)
+
+    for _, target := range []string{
+        "/link",
+        "/dirlink/secret",
+        "/..%2fsecret",
+        "/..%5csecret",
+        "/%2e%2e/secret",
+        "/a/..%252f..%252fsecret",
+    } {
+        rec := get(h, target)
+        if rec.Code != http.StatusNotFound || strings.Contains(rec.Body.String(), "secret") {
+            t.Errorf("GET %s: %d %q, want 404", target, rec.Code, rec.Body)
+        }
+    }
+
+    // Backslashes are not valid in a request target, but a client may
+    // still send them.
+    for _, p := range []string{`/a\..\..\secret`, `/..\secret`, `/a/..\..\secret`} {
+        req := httptest.NewRequest("GET", "/", nil)
+        req.URL.Path = p
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+        if rec.Code != http.StatusNotFound || strings.Contains(rec.Body.String(), "secret") {
+            t.Errorf("GET %s: %d %q, want 404", p, rec.Code, rec.Body)
+        }
+    }
+
+    if rec := get(h, "/a/page.html"); rec.Code != http.StatusOK || rec.Body.String() != "page" {
+        t.Errorf("GET /a/page.html: %d %q", rec.Code, rec.Body)
+    }
+}
+
+func TestMimeTypes(t *testing.T) {
+    if err := registerMimeTypes(); err != nil {
+        t.Fatal(err)
+    }
+    root := writeTree(t, map[string]string{
+        "app.wasm": "\x00asm",
+        "app.js":   "0",
+    })
+    h := newTestFileHandler(t, root)
+    for target, want := range map[string]string{
+        "/app.wasm": "application/wasm",
+        "/app.js":   "text/javascript; charset=utf-8",
+    } {
+        if got := get(h, target).Header().Get("Content-Type"); got != want {
+            t.Errorf("GET %s: Content-Type %q, want %q", target, got, want)
+        }
+    }
+}
+
+func TestSPA(t *testing.T) {
+    root := writeTree(t, map[string]string{
+        "index.html": "index",
+        "app.js":     "app",
+    })
+    h := newTestFileHandler(t, root)
+    h.spa = true
+
+    for _, target := range []string{"/play", "/rooms/ABCD", "/a/b/c"} {
+        rec := get(h, target)
+        if rec.Code != http.StatusOK || rec.Body.String() != "index" {
+            t.Errorf("GET %s: %d %q, want the index", target, rec.Code, rec.Body)
+        }
+    }
+    for _, target := range []string{"/missing.js", "/a/b/app.wasm"} {
+        if rec := get(h, target); rec.Code != http.StatusNotFound {
+            t.Errorf("GET %s: %d, want 404", target, rec.Code)
+        }
+    }
+    if rec := get(h, "/app.js"); rec.Body.String() != "app" {
+        t.Errorf("GET /app.js: %q", rec.Body)
+    }
+
+    h.spa = false
+    if rec := get(h, "/play"); rec.Code != http.StatusNotFound {
+        t.Errorf("GET /play without -spa: %d, want 404", rec.Code)
+    }
+}
+
+func TestPrecompressed(t *testing.T) {
+    if err := registerMimeTypes(); err != nil {
+        t.Fatal(err)
+    }
+    root := writeTree(t, map[string]string{
+        "gz/app.wasm":      "plain",
+        "gz/app.wasm.gz":   "gzipped",
+        "br/app.wasm":      "plain",
+        "br/app.wasm.br":   "brotli",
+        "both/app.wasm":    "plain",
+        "both/app.wasm.gz": "gzipped",
+        "both/app.wasm.br": "brotli",
+        "none/app.wasm":    "plain",
+    })
+    h := newTestFileHandler(t, root)
+
+    for _, tt := range []struct {
+        dir, accept, encoding, body string
+    }{
+        {"gz", "br, gzip", "gzip", "gzipped"},
+        {"br", "br, gzip", "br", "brotli"},
+        {"both", "br, gzip", "br", "brotli"},
+        {"both", "gzip", "gzip", "gzipped"},
+        {"both", "br;q=0.5, gzip", "gzip", "gzipped"},
+        {"both", "", "", "plain"},
+        {"none", "br, gzip", "", "plain"},
+    } {
+        rec := get(h, "/"+tt.dir+"/app.wasm", "Accept-Encoding", tt.accept)
+        if rec.Code != http.StatusOK || rec.Body.String() != tt.body ||
+                rec.Header().Get("Content-Encoding") != tt.encoding {
+            t.Errorf("%s with %q: %d %q encoded %q, want %q encoded %q",
+                tt.dir, tt.accept, rec.Code, rec.Body,
+                rec.Header().Get("Content-Encoding"), tt.body, tt.encoding)
+        }
+        if got := rec.Header().Get("Content-Type"); got != "application/wasm" {
+            t.Errorf("%s with %q: Content-Type %q", tt.dir, tt.accept, got)
+        }
+    }
+}
+
+func TestNotFound(t *testing.T) {
+    custom := newTestFileHandler(t, writeTree(t, map[string]string{
+        "index.html": "index",
+        "404.html":   "<h1>lost</h1>",
+    }))
+    rec := get(custom, "/missing.js")
+    if rec.Code != http.StatusNotFound || rec.Body.String() != "<h1>lost</h1>" {
+        t.Errorf("with 404.html: %d %q", rec.Code, rec.Body)
+    }
+    if got := rec.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+        t.Errorf("with 404.html: Content-Type %q", got)
+    }
+
+    plain := newTestFileHandler(t, writeTree(t, map[string]string{"index.html": "index"}))
+    rec = get(plain, "/missing.js")
+    if rec.Code != http.StatusNotFound || rec.Body.String() != "404 page not found\n" {
+        t.Errorf("without 404.html: %d %q", rec.Code, rec.Body)
+    }
+}
+
+func TestRoots(t *testing.T) {
+    first := writeTree(t, map[string]string{
+        "index.html": "first index",
+    })
+    second := writeTree(t, map[string]string{
+        "index.html":  "second index",
+        "assets/a.js": "second a",
+    })
+    h := newTestFileHandler(t, first, second)
+    for target, want := range map[string]string{
+        "/index.html":  "first index",
+        "/assets/a.js": "second a",
+    } {
+        if rec := get(h, target); rec.Code != http.StatusOK || rec.Body.String() != want {
+            t.Errorf("GET %s: %d %q, want %q", target, rec.Code, rec.Body, want)
+        }
+    }
+    if rec := get(h, "/assets/b.js"); rec.Code != http.StatusNotFound {
+        t.Errorf("GET /assets/b.js: %d, want 404", rec.Code)
+    }
+}
+
+func TestDirectories(t *testing.T) {
+    h := newTestFileHandler(t, writeTree(t, map[string]string{
+        "index.html":        "root index",
+        "subdir/index.html": "subdir index",
+        "bare/app.js":       "0",
+        "a b?%c/index.html": "odd index",
+    }))
+
+    for target, want := range map[string]string{
+        "/subdir?v=1":   "./subdir/?v=1",
+        "/a%20b%3F%25c": "./a%20b%3F%25c/",
+    } {
+        rec := get(h, target)
+        if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != want {
+            t.Errorf("GET %s: %d to %q, want 301 to %s",
+                target, rec.Code, rec.Header().Get("Location"), want)
+        }
+    }
+    for target, want := range map[string]string{
+        "/":              "root index",
+        "/subdir/":       "subdir index",
+        "/a%20b%3F%25c/": "odd index",
+    } {
+        if rec := get(h, target); rec.Code != http.StatusOK || rec.Body.String() != want {
+            t.Errorf("GET %s: %d %q, want %q", target, rec.Code, rec.Body, want)
+        }
+    }
+    if rec := get(h, "/bare/"); rec.Code != http.StatusNotFound {
+        t.Errorf("GET /bare/: %d, want 404", rec.Code)
+    }
+}
+
+func TestPreload(t *testing.T) {
+    h := newTestFileHandler(t, writeTree(t, map[string]string{
+        "index.html":     "index",
+        "app.js":         "0",
+        "app.wasm":       "\x00asm",
+        "two/index.html": "index",
+        "two/a.wasm":     "\x00asm",
+        "two/b.wasm":     "\x00asm",
+    }))
+
+    links := get(h, "/").Header().Values("Link")
+    want := []string{
+        "</app.wasm>; rel=preload; as=fetch; crossorigin",
+        "</app.js>; rel=preload; as=script",
+    }
+    if strings.Join(links, "\n") != strings.Join(want, "\n") {
+        t.Errorf("Link on /: %q, want %q", links, want)
+    }
+    for _, target := range []string{"/app.js", "/app.wasm", "/two/"} {
+        if links := get(h, target).Header().Values("Link"); len(links) > 0 {
+            t.Errorf("Link on %s: %q", target, links)
+        }
+    }
+
+    h.preload = []string{"/style.css"}
+    links = get(h, "/index.html").Header().Values("Link")
+    if len(links) != 1 || links[0] != "</style.css>; rel=preload; as=style" {
+        t.Errorf("configured Link: %q", links)
+    }
+}
+
+func TestCheckRoot(t *testing.T) {
+    root := writeTree(t, map[string]string{"index.html": "index"})
+    if err := checkRoot(root); err != nil {
+        t.Errorf("existing root: %v", err)
+    }
+    missing := filepath.Join(root, "zig-out")
+    if err := checkRoot(missing); err == nil || !strings.Contains(err.Error(), "does not exist") {
+        t.Errorf("missing root: %v, want an error saying it does not exist", err)
+    }
+    if err := checkRoot(filepath.Join(root, "index.html")); err == nil ||
+            !strings.Contains(err.Error(), "not a directory") {
+        t.Errorf("file as root: %v, want an error saying it is not a directory", err)
+    }
+}
+
+func TestResolvePath(t *testing.T) {
+    root := filepath.FromSlash("/srv/www")
+    for _, tt := range []struct {
+        urlPath string
+        want    string
+        err     error
+    }{
+        {"/", root, nil},
+        {"/app.wasm", filepath.Join(root, "app.wasm"), nil},
+        {"/a/./b//c", filepath.Join(root, "a", "b", "c"), nil},
+        {"/a/b/../c", "", errOutsideRoot},
+        {`/a\b`, filepath.Join(root, "a", "b"), nil},
+        {"/../etc/passwd", "", errOutsideRoot},
+        {`/a\..\..\x`, "", errOutsideRoot},
+        {"/a/%2f/b", "", errBadPath},
+        {"/a%5Cb", "", errBadPath},
+        {"/a\x00b", "", errBadPath},
+        {"/" + strings.Repeat("a", maxPathLen), "", errBadPath},
+    } {
+        got, err := resolvePath(root, tt.urlPath)
+        if got != tt.want || err != tt.err {
+            t.Errorf("resolvePath(%q) = %q, %v; want %q, %v",
+                tt.urlPath, got, err, tt.want, tt.err)
+        }
+    }
+}
+
+func FuzzResolvePath(f *testing.F) {
+    for _, seed := range []string{
+        "/",
+        "/index.html",
+        "/..%2f..%2fetc/passwd",
+        "/a%5c..%5c..%5cx",
+        "/a\x00b",
+        `/a\..\..\x`,
+        "/" + strings.Repeat("a/", maxPathLen/2+1),
+    } {
+        f.Add(seed)
+    }
+    root := filepath.FromSlash("/srv/www")
+    f.Fuzz(func(t *testing.T, urlPath string) {
+        got, err := resolvePath(root, urlPath)
+        if err == nil && !within(root, got) {
+            t.Errorf("resolvePath(%q) = %q, outside %s", urlPath, got, root)
+        }
+    })
+}
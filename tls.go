@@ -0,0 +1,52 @@
+package main
+
+import (
+    "crypto/tls"
+    "net"
+    "net/http"
+    "strings"
+
+    "golang.org/x/crypto/acme/autocert"
+)
+
+// tlsConfig builds the configuration for serving HTTPS from either a static
+// certificate pair or Let's Encrypt. It returns a nil config when neither is
+// requested, and the autocert manager when one is in use so that its
+// HTTP-01 challenge handler can be mounted on the plain listener.
+func tlsConfig(
+    certFile, keyFile, domains, cacheDir string,
+) (*tls.Config, *autocert.Manager, error) {
+    switch {
+    case certFile != "":
+        cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+        if err != nil {
+            return nil, nil, err
+        }
+        return &tls.Config{Certificates: []tls.Certificate{cert}}, nil, nil
+    case domains != "":
+        m := &autocert.Manager{
+            Prompt:     autocert.AcceptTOS,
+            HostPolicy: autocert.HostWhitelist(strings.Split(domains, ",")...),
+            Cache:      autocert.DirCache(cacheDir),
+        }
+        return m.TLSConfig(), m, nil
+    }
+    return nil, nil, nil
+}
+
+// redirectHTTPS sends plain HTTP requests to the same path on the HTTPS
+// listener at tlsAddr.
+func redirectHTTPS(tlsAddr string) http.Handler {
+    _, port, _ := net.SplitHostPort(tlsAddr)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        host := r.Host
+        if h, _, err := net.SplitHostPort(host); err == nil {
+            host = h
+        }
+        if port != "" && port != "443" {
+            host = net.JoinHostPort(host, port)
+        }
+        http.Redirect(w, r, "https://"+host+r.URL.RequestURI(),
+            http.StatusMovedPermanently)
+    })
+}
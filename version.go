@@ -0,0 +1,52 @@
+package main
+
+import (
+    "net/http"
+    "runtime/debug"
+    "sync"
+)
+
+// version and buildTime can be stamped in at build time with
+//
+//    go build -ldflags "-X main.version=1.2.0 -X main.buildTime=$(date -u +%FT%TZ)"
+var (
+    version   string
+    buildTime string
+)
+
+type versionInfo struct {
+    Version       string `json:"version,omitempty"`
+    ModuleVersion string `json:"moduleVersion"`
+    GoVersion     string `json:"goVersion"`
+    Revision      string `json:"revision,omitempty"`
+    Modified      bool   `json:"modified,omitempty"`
+    // BuildTime falls back to the time of the commit built, as Go does not
+    // record when the build ran.
+    BuildTime string `json:"buildTime,omitempty"`
+}
+
+var buildVersion = sync.OnceValue(func() versionInfo {
+    v := versionInfo{Version: version, BuildTime: buildTime}
+    bi, ok := debug.ReadBuildInfo()
+    if !ok {
+        return v
+    }
+    v.ModuleVersion, v.GoVersion = bi.Main.Version, bi.GoVersion
+    for _, s := range bi.Settings {
+        switch s.Key {
+        case "vcs.revision":
+            v.Revision = s.Value
+        case "vcs.modified":
+            v.Modified = s.Value == "true"
+        case "vcs.time":
+            if v.BuildTime == "" {
+                v.BuildTime = s.Value
+            }
+        }
+    }
+    return v
+})
+
+func serveVersion(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, buildVersion())
+}
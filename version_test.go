@@ -0,0 +1,39 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "runtime"
+    "testing"
+)
+
+func TestServeVersion(t *testing.T) {
+    rec := get(http.HandlerFunc(serveVersion), "/version")
+    if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+        t.Fatalf("%d %q", rec.Code, rec.Header().Get("Content-Type"))
+    }
+    var fields map[string]any
+    if err := json.Unmarshal(rec.Body.Bytes(), &fields); err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := fields["moduleVersion"].(string); !ok {
+        t.Errorf("moduleVersion is %#v, want a string", fields["moduleVersion"])
+    }
+    if v := fields["goVersion"]; v != runtime.Version() {
+        t.Errorf("goVersion is %#v, want %q", v, runtime.Version())
+    }
+    for key, v := range fields {
+        switch key {
+        case "version", "moduleVersion", "goVersion", "revision", "buildTime":
+            if _, ok := v.(string); !ok {
+                t.Errorf("%s is %#v, want a string", key, v)
+            }
+        case "modified":
+            if _, ok := v.(bool); !ok {
+                t.Errorf("modified is %#v, want a bool", v)
+            }
+        default:
+            t.Errorf("unexpected field %s", key)
+        }
+    }
+}
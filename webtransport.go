@@ -0,0 +1,173 @@
+package main
+
+import (
+    "crypto/tls"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "time"
+
+    "github.com/gorilla/websocket"
+    "github.com/quic-go/quic-go"
+    "github.com/quic-go/quic-go/http3"
+    "github.com/quic-go/webtransport-go"
+)
+
+// newWebTransport serves the relay over HTTP/3 WebTransport at /wt on the
+// UDP port addr. Clients send unreliable messages, such as position updates,
+// as datagrams, and open one bidirectional stream for reliable ones, such as
+// chat. Stream messages are framed with a little-endian uint32 length.
+// Events from the relay itself, such as a new host, arrive as JSON on
+// unidirectional streams opened by the server. The handler for /wt is wrapped
+// in middleware.
+func newWebTransport(
+    addr string, tlsConf *tls.Config, rl *relay,
+    middleware func(http.Handler) http.Handler,
+) *webtransport.Server {
+    mux := http.NewServeMux()
+    wt := &webtransport.Server{
+        H3: &http3.Server{
+            Addr:       addr,
+            TLSConfig:  http3.ConfigureTLSConfig(tlsConf),
+            QUICConfig: &quic.Config{
+                EnableDatagrams:                  true,
+                EnableStreamResetPartialDelivery: true,
+            },
+            Handler: middleware(mux),
+        },
+    }
+    mux.HandleFunc("/wt", func(w http.ResponseWriter, r *http.Request) {
+        rl.serveWebTransport(wt, w, r)
+    })
+    return wt
+}
+
+func (rl *relay) serveWebTransport(wt *webtransport.Server, w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    if q.Get("room") == "" && q.Get("token") == "" {
+        http.Error(w, "missing room or token", http.StatusBadRequest)
+        return
+    }
+    if rl.isDraining() {
+        http.Error(w, shutdownReason, http.StatusServiceUnavailable)
+        return
+    }
+    sess, err := wt.Upgrade(http3Writer(w), r)
+    if err != nil {
+        http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+
+    c := &client{
+        addr:      sess.RemoteAddr(),
+        id:        requestID(r.Context()),
+        datagram:  sess.SendDatagram,
+        logFrames: rl.logFrames,
+        notify: func(event []byte) {
+            go func() {
+                str, err := sess.OpenUniStream()
+                if err != nil {
+                    return
+                }
+                str.SetWriteDeadline(time.Now().Add(writeWait))
+                str.Write(event)
+                str.Close()
+            }()
+        },
+        goAway: func() {
+            sess.CloseWithError(webtransport.SessionErrorCode(
+                websocket.CloseGoingAway), shutdownReason)
+        },
+        abort: func(code int, reason string) {
+            sess.CloseWithError(webtransport.SessionErrorCode(code), reason)
+        },
+    }
+    p, code, reason := rl.attach(q, c)
+    if p == nil {
+        sess.CloseWithError(webtransport.SessionErrorCode(code), reason)
+        return
+    }
+    defer rl.leave(p, c)
+
+    str, err := sess.AcceptStream(sess.Context())
+    if err != nil {
+        return
+    }
+    hello, _ := json.Marshal(p.room.welcome(p))
+    str.SetWriteDeadline(time.Now().Add(writeWait))
+    if err := writeFrame(str, hello); err != nil {
+        return
+    }
+    go streamWriteLoop(sess, str, c.send)
+    go func() {
+        for {
+            msg, err := sess.ReceiveDatagram(sess.Context())
+            if err != nil {
+                return
+            }
+            c.received("datagram", msg)
+            p.room.broadcastDatagram(p, msg)
+        }
+    }()
+
+    for {
+        msg, err := readFrame(str)
+        if err != nil {
+            return
+        }
+        c.received("message", msg)
+        p.room.broadcast(p, msg)
+    }
+}
+
+// isWebTransport reports whether r asks to open a WebTransport session.
+func isWebTransport(r *http.Request) bool {
+    return r.Method == http.MethodConnect && r.Proto == "webtransport"
+}
+
+// http3Writer finds the HTTP/3 response writer beneath any middleware, as
+// upgrading to WebTransport needs its underlying stream.
+func http3Writer(w http.ResponseWriter) http.ResponseWriter {
+    for {
+        if _, ok := w.(http3.HTTPStreamer); ok {
+            return w
+        }
+        u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+        if !ok {
+            return w
+        }
+        w = u.Unwrap()
+    }
+}
+
+func streamWriteLoop(sess *webtransport.Session, str *webtransport.Stream, send <-chan []byte) {
+    for msg := range send {
+        str.SetWriteDeadline(time.Now().Add(writeWait))
+        if err := writeFrame(str, msg); err != nil {
+            break
+        }
+    }
+    sess.CloseWithError(0, "")
+}
+
+func writeFrame(w io.Writer, msg []byte) error {
+    frame := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+len(msg)), uint32(len(msg)))
+    _, err := w.Write(append(frame, msg...))
+    return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+    var header [4]byte
+    if _, err := io.ReadFull(r, header[:]); err != nil {
+        return nil, err
+    }
+    n := binary.LittleEndian.Uint32(header[:])
+    if n > maxMessageSize {
+        return nil, errors.New("message too large")
+    }
+    msg := make([]byte, n)
+    _, err := io.ReadFull(r, msg)
+    return msg, err
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/json"
+    "math/big"
+    "net"
+    "net/http"
+    "testing"
+    "time"
+
+    "github.com/quic-go/quic-go"
+    "github.com/quic-go/webtransport-go"
+)
+
+// testCert returns a self-signed certificate for 127.0.0.1.
+func testCert(t *testing.T) tls.Certificate {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tmpl := &x509.Certificate{
+        SerialNumber: big.NewInt(1),
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+        IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+    }
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// startWebTransport serves rl over WebTransport on a loopback port and
+// returns the URL of /wt.
+func startWebTransport(
+    t *testing.T, rl *relay, middleware func(http.Handler) http.Handler,
+) string {
+    t.Helper()
+    conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    tlsConf := &tls.Config{Certificates: []tls.Certificate{testCert(t)}}
+    wt := newWebTransport(conn.LocalAddr().String(), tlsConf, rl, middleware)
+    go wt.Serve(conn)
+    t.Cleanup(func() {
+        wt.Close()
+        conn.Close()
+    })
+    return "https://" + conn.LocalAddr().String() + "/wt"
+}
+
+func dialWebTransport(
+    t *testing.T, url string, header http.Header,
+) (*http.Response, *webtransport.Session, error) {
+    t.Helper()
+    d := &webtransport.Transport{
+        TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+        QUICConfig: &quic.Config{
+            EnableDatagrams:                  true,
+            EnableStreamResetPartialDelivery: true,
+        },
+    }
+    t.Cleanup(func() { d.Close() })
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    return d.Dial(ctx, url, header)
+}
+
+func TestWebTransportMiddleware(t *testing.T) {
+    logged := make(chan int, 4)
+    middleware := func(h http.Handler) http.Handler {
+        h = basicAuth(h, passwordChecker("player", "secret", ""))
+        h = recoverPanics(h)
+        return logRequests(h, func(r *http.Request, status int, size int64, d time.Duration) {
+            logged <- status
+        }, nil)
+    }
+    url := startWebTransport(t, newRelay(4, 0), middleware) + "?room=r"
+
+    rsp, _, err := dialWebTransport(t, url, nil)
+    if err == nil || rsp == nil || rsp.StatusCode != http.StatusUnauthorized {
+        t.Fatalf("dial without credentials: %v, %v; want 401", rsp, err)
+    }
+    if status := <-logged; status != http.StatusUnauthorized {
+        t.Errorf("logged status %d, want 401", status)
+    }
+
+    header := http.Header{}
+    req := &http.Request{Header: header}
+    req.SetBasicAuth("player", "secret")
+    _, sess, err := dialWebTransport(t, url, header)
+    if err != nil {
+        t.Fatalf("dial with credentials: %v", err)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    str, err := sess.OpenStreamSync(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // The server only sees the stream once something is sent on it.
+    if err := writeFrame(str, []byte("hi")); err != nil {
+        t.Fatal(err)
+    }
+    msg, err := readFrame(str)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var w welcome
+    if err := json.Unmarshal(msg, &w); err != nil {
+        t.Fatalf("welcome %q: %v", msg, err)
+    }
+}
+
+// joinWebTransport dials url, opens the session's stream and reads the
+// welcome from it.
+func joinWebTransport(t *testing.T, url string) (*webtransport.Session, *webtransport.Stream) {
+    t.Helper()
+    _, sess, err := dialWebTransport(t, url, nil)
+    if err != nil {
+        t.Fatalf("dial: %v", err)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    str, err := sess.OpenStreamSync(ctx)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // The server only sees the stream once something is sent on it.
+    if err := writeFrame(str, []byte("joined")); err != nil {
+        t.Fatal(err)
+    }
+    str.SetReadDeadline(time.Now().Add(5 * time.Second))
+    if _, err := readFrame(str); err != nil {
+        t.Fatalf("welcome: %v", err)
+    }
+    return sess, str
+}
+
+func TestWebTransportRelay(t *testing.T) {
+    url := startWebTransport(t, newRelay(4, 0), withRequestID) + "?room=r"
+    sessA, strA := joinWebTransport(t, url)
+    sessB, strB := joinWebTransport(t, url)
+
+    // B's first frame doubles as proof that it has joined the room.
+    strA.SetReadDeadline(time.Now().Add(5 * time.Second))
+    if msg, err := readFrame(strA); err != nil || string(msg) != "joined" {
+        t.Fatalf("A read %q, %v; want B's joined", msg, err)
+    }
+
+    if err := sessA.SendDatagram([]byte("pos")); err != nil {
+        t.Fatal(err)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if msg, err := sessB.ReceiveDatagram(ctx); err != nil || string(msg) != "pos" {
+        t.Errorf("B datagram %q, %v; want pos", msg, err)
+    }
+    ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+    defer cancel()
+    if msg, err := sessA.ReceiveDatagram(ctx); err == nil {
+        t.Errorf("A received its own datagram %q", msg)
+    }
+
+    if err := writeFrame(strA, []byte("chat")); err != nil {
+        t.Fatal(err)
+    }
+    strB.SetReadDeadline(time.Now().Add(5 * time.Second))
+    if msg, err := readFrame(strB); err != nil || string(msg) != "chat" {
+        t.Errorf("B stream %q, %v; want chat", msg, err)
+    }
+    strA.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+    if msg, err := readFrame(strA); err == nil {
+        t.Errorf("A received its own stream message %q", msg)
+    }
+}